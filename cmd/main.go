@@ -1,7 +1,10 @@
 package main
 
 import (
+	"context"
 	"os"
+	"os/signal"
+	"syscall"
 
 	log "github.com/sirupsen/logrus"
 
@@ -10,11 +13,15 @@ import (
 )
 
 func main() {
+	// Cancel every running query and transfer on Ctrl-C or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	opts := options.NewPGBouncerUpdaterOptions()
 	rootCmd := cmd.NewCmdPGBouncerUpdate(opts.WithDefaultOptions())
-	if err := rootCmd.Execute(); err != nil {
-		log.Error("Whoops. There was an error while executing your CLI '%s'", err)
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
+		log.Errorf("Whoops. There was an error while executing your CLI '%s'", err)
+		stop()
 		os.Exit(1)
 	}
 }
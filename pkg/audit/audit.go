@@ -0,0 +1,29 @@
+package audit
+
+import "io"
+
+// Query returns every role with the attributes the audit needs.
+const Query = "select rolname, rolpassword, rolsuper, rolreplication, rolcanlogin from pg_authid order by rolname asc"
+
+type Audits interface {
+	// Add classifies a role, exposed is true when the export query selects it
+	Add(role *Role, exposed bool)
+	// WithAuthType checks roles added next against the auth_type of a PGBouncer host
+	WithAuthType(host, authType string) Audits
+	// Violations returns the number of roles breaking the policy
+	Violations() int
+	WriteTable(w io.Writer) error
+	WriteJSON(w io.Writer) error
+}
+
+func NewAudit(policy *Policy) Audits {
+	if policy == nil {
+		policy = new(Policy)
+	}
+
+	return &Report{
+		policy:    policy,
+		AuthTypes: map[string]string{},
+		Roles:     []*Role{},
+	}
+}
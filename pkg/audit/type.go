@@ -0,0 +1,192 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+type Verifier string
+
+const (
+	MD5       Verifier = "md5"
+	SCRAM     Verifier = "scram-sha-256"
+	Plaintext Verifier = "plaintext"
+	Empty     Verifier = "empty"
+	Null      Verifier = "null"
+)
+
+var (
+	md5Verifier   = regexp.MustCompile(`^md5[0-9a-f]{32}$`)
+	scramVerifier = regexp.MustCompile(`^SCRAM-SHA-256\$[0-9]+:[A-Za-z0-9+/=]+\$[A-Za-z0-9+/=]+:[A-Za-z0-9+/=]+$`)
+)
+
+// Policy tells which findings are violations on top of the ones always
+// reported: plaintext and empty passwords, superuser or replication roles
+// exposed through PGBouncer and verifiers PGBouncer can't check.
+type Policy struct {
+	DenyMD5 bool `yaml:"deny_md5,omitempty"`
+	// PGBouncer auth_type, read from each host admin console when unset
+	AuthType string `yaml:"auth_type,omitempty"`
+	// Exposed roles allowed to be superuser or replication
+	AllowedRoles []string `yaml:"allowed_roles,omitempty"`
+}
+
+type Role struct {
+	Name        string   `json:"name"`
+	Verifier    Verifier `json:"verifier"`
+	Superuser   bool     `json:"superuser"`
+	Replication bool     `json:"replication"`
+	CanLogin    bool     `json:"can_login"`
+	Exposed     bool     `json:"exposed"`
+	Issues      []string `json:"issues,omitempty"`
+}
+
+type Report struct {
+	policy         *Policy
+	AuthTypes      map[string]string `json:"auth_types"`
+	Roles          []*Role           `json:"roles"`
+	ViolationCount int               `json:"violations"`
+}
+
+// Classify returns the kind of verifier stored in pg_authid.rolpassword.
+func Classify(rolpassword string, valid bool) Verifier {
+	switch {
+	case !valid:
+		return Null
+	case rolpassword == "":
+		return Empty
+	case md5Verifier.MatchString(rolpassword):
+		return MD5
+	case scramVerifier.MatchString(rolpassword):
+		return SCRAM
+	default:
+		return Plaintext
+	}
+}
+
+// Compatible reports whether PGBouncer can check a password against verifier
+// with authType, auth types PGBouncer does not check itself always are.
+func Compatible(authType string, verifier Verifier) bool {
+	switch authType {
+	case "md5", "plain":
+		return verifier == MD5 || verifier == SCRAM || verifier == Plaintext
+	case "scram-sha-256":
+		return verifier == SCRAM || verifier == Plaintext
+	default:
+		return true
+	}
+}
+
+func (r *Report) WithAuthType(host, authType string) Audits {
+	r.AuthTypes[host] = authType
+	return r
+}
+
+func (r *Report) Add(role *Role, exposed bool) {
+	role.Exposed = exposed
+	role.Issues = r.check(role)
+	if len(role.Issues) > 0 {
+		r.ViolationCount++
+	}
+
+	r.Roles = append(r.Roles, role)
+}
+
+func (r *Report) Violations() int {
+	return r.ViolationCount
+}
+
+func (r *Report) check(role *Role) []string {
+	issues := []string{}
+
+	switch role.Verifier {
+	case Plaintext:
+		issues = append(issues, "plaintext password")
+	case Empty:
+		issues = append(issues, "empty password")
+	case MD5:
+		if r.policy.DenyMD5 {
+			issues = append(issues, "md5 password")
+		}
+	}
+
+	if !role.Exposed {
+		return issues
+	}
+
+	if !r.allowed(role.Name) {
+		if role.Superuser {
+			issues = append(issues, "superuser exposed through PGBouncer")
+		}
+
+		if role.Replication {
+			issues = append(issues, "replication role exposed through PGBouncer")
+		}
+	}
+
+	hosts := make([]string, 0, len(r.AuthTypes))
+	for host := range r.AuthTypes {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	for _, host := range hosts {
+		authType := r.AuthTypes[host]
+		if !Compatible(authType, role.Verifier) {
+			issues = append(issues, fmt.Sprintf("%s verifier incompatible with auth_type %s on %s", role.Verifier, authType, host))
+		}
+	}
+
+	return issues
+}
+
+func (r *Report) allowed(roleName string) bool {
+	for _, name := range r.policy.AllowedRoles {
+		if name == roleName {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *Report) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ROLE\tVERIFIER\tLOGIN\tSUPERUSER\tREPLICATION\tEXPOSED\tISSUES")
+	for _, role := range r.Roles {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			role.Name,
+			role.Verifier,
+			yesNo(role.CanLogin),
+			yesNo(role.Superuser),
+			yesNo(role.Replication),
+			yesNo(role.Exposed),
+			strings.Join(role.Issues, ", "),
+		)
+	}
+
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(w, "\n%d role(s) audited, %d violation(s)\n", len(r.Roles), r.ViolationCount)
+	return err
+}
+
+func (r *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
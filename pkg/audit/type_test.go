@@ -0,0 +1,167 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	md5Password   = "md53175bce1d3201d16594cebf9d7eb3f9d"
+	scramPassword = "SCRAM-SHA-256$4096:c2FsdHNhbHRzYWx0$c3RvcmVka2V5c3RvcmVka2V5c3RvcmVka2V5c3Q=:c2VydmVya2V5c2VydmVya2V5c2VydmVya2V5c2U="
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name        string
+		rolpassword string
+		valid       bool
+		want        Verifier
+	}{
+		{name: "md5", rolpassword: md5Password, valid: true, want: MD5},
+		{name: "scram", rolpassword: scramPassword, valid: true, want: SCRAM},
+		{name: "plaintext", rolpassword: "secret", valid: true, want: Plaintext},
+		{name: "md5 prefix", rolpassword: "md5secret", valid: true, want: Plaintext},
+		{name: "empty", rolpassword: "", valid: true, want: Empty},
+		{name: "null", rolpassword: "", valid: false, want: Null},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Classify(tt.rolpassword, tt.valid); got != tt.want {
+				t.Errorf("Classify() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompatible(t *testing.T) {
+	tests := []struct {
+		authType string
+		verifier Verifier
+		want     bool
+	}{
+		{authType: "md5", verifier: MD5, want: true},
+		{authType: "md5", verifier: SCRAM, want: true},
+		{authType: "md5", verifier: Null, want: false},
+		{authType: "scram-sha-256", verifier: MD5, want: false},
+		{authType: "scram-sha-256", verifier: SCRAM, want: true},
+		{authType: "scram-sha-256", verifier: Empty, want: false},
+		{authType: "trust", verifier: Null, want: true},
+		{authType: "hba", verifier: MD5, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.authType+"/"+string(tt.verifier), func(t *testing.T) {
+			if got := Compatible(tt.authType, tt.verifier); got != tt.want {
+				t.Errorf("Compatible() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReport_Add(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  *Policy
+		role    *Role
+		exposed bool
+		want    []string
+	}{
+		{
+			name:    "Scram application role",
+			role:    &Role{Name: "app", Verifier: SCRAM, CanLogin: true},
+			exposed: true,
+			want:    []string{},
+		},
+		{
+			name:    "Md5 application role",
+			role:    &Role{Name: "app", Verifier: MD5, CanLogin: true},
+			exposed: true,
+			want:    []string{"md5 verifier incompatible with auth_type scram-sha-256 on pgbouncer-02"},
+		},
+		{
+			name:    "Md5 denied",
+			policy:  &Policy{DenyMD5: true},
+			role:    &Role{Name: "app", Verifier: MD5, CanLogin: true},
+			exposed: false,
+			want:    []string{"md5 password"},
+		},
+		{
+			name:    "Exposed superuser",
+			role:    &Role{Name: "postgres", Verifier: SCRAM, Superuser: true, Replication: true},
+			exposed: true,
+			want:    []string{"superuser exposed through PGBouncer", "replication role exposed through PGBouncer"},
+		},
+		{
+			name:    "Allowed superuser",
+			policy:  &Policy{AllowedRoles: []string{"postgres"}},
+			role:    &Role{Name: "postgres", Verifier: SCRAM, Superuser: true},
+			exposed: true,
+			want:    []string{},
+		},
+		{
+			name:    "Hidden superuser",
+			role:    &Role{Name: "postgres", Verifier: SCRAM, Superuser: true},
+			exposed: false,
+			want:    []string{},
+		},
+		{
+			name:    "Plaintext",
+			role:    &Role{Name: "legacy", Verifier: Plaintext},
+			exposed: false,
+			want:    []string{"plaintext password"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := NewAudit(tt.policy).
+				WithAuthType("pgbouncer-01", "md5").
+				WithAuthType("pgbouncer-02", "scram-sha-256")
+
+			report.Add(tt.role, tt.exposed)
+			if !reflect.DeepEqual(tt.role.Issues, tt.want) {
+				t.Errorf("Report.Add() issues = %v, want %v", tt.role.Issues, tt.want)
+			}
+
+			wantViolations := 0
+			if len(tt.want) > 0 {
+				wantViolations = 1
+			}
+			if got := report.Violations(); got != wantViolations {
+				t.Errorf("Report.Violations() = %v, want %v", got, wantViolations)
+			}
+		})
+	}
+}
+
+func TestReport_Write(t *testing.T) {
+	report := NewAudit(nil).WithAuthType("pgbouncer-01", "md5")
+	report.Add(&Role{Name: "app", Verifier: MD5, CanLogin: true}, true)
+	report.Add(&Role{Name: "legacy", Verifier: Plaintext}, false)
+
+	table := new(bytes.Buffer)
+	if err := report.WriteTable(table); err != nil {
+		t.Fatalf("Report.WriteTable() error = %v", err)
+	}
+	if !strings.Contains(table.String(), "legacy  plaintext") || !strings.Contains(table.String(), "2 role(s) audited, 1 violation(s)") {
+		t.Errorf("Report.WriteTable() = %s", table.String())
+	}
+
+	out := new(bytes.Buffer)
+	if err := report.WriteJSON(out); err != nil {
+		t.Fatalf("Report.WriteJSON() error = %v", err)
+	}
+
+	got := struct {
+		AuthTypes  map[string]string `json:"auth_types"`
+		Roles      []*Role           `json:"roles"`
+		Violations int               `json:"violations"`
+	}{}
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("Report.WriteJSON() invalid json %v", err)
+	}
+	if got.Violations != 1 || len(got.Roles) != 2 || got.AuthTypes["pgbouncer-01"] != "md5" {
+		t.Errorf("Report.WriteJSON() = %s", out.String())
+	}
+}
@@ -1,18 +1,30 @@
 package aio
 
 import (
+	"sort"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/cmd/copy"
 	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/cmd/list"
 	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/cmd/options"
 	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/cmd/reload"
 	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/configuration"
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/state"
 )
 
 const (
 	getApplicationExample = `
 	# Write config in current dir with default vars
 	%[1]s aio --config config.yaml 
+
+	# Push to every host even if roles did not change since the last run
+	%[1]s aio --config config.yaml --resync
+
+	# Push even when the safety guards refuse the new userlist, or roles did
+	# not change
+	%[1]s aio --config config.yaml --force
 	`
 
 	getUsage = `
@@ -40,24 +52,150 @@ func NewCmdAIO(o *options.Options) *cobra.Command {
 				conf = configuration.NewDefaultConfiguration(o.UserName, o.DBName, o.PGHost, o.Password, o.PGBouncerHosts...)
 			}
 
-			if err := list.ListCmd(c, o, conf); err != nil {
-				return err
-			}
-
-			if err := copy.CopyCmd(c, o, conf); err != nil {
-				return err
-			}
-
-			if err := reload.ReloadCmd(c, o, conf); err != nil {
-				return err
-			}
-
-			return nil
+			return AIOCmd(c, o, conf)
 		},
 	}
 
 	o.WithDefaultFlags(cmd)
 	cmd.Flags().BoolVar(&o.Sudo, "sudo", o.Sudo, "Copy file as sudoer")
 	cmd.Flags().StringVar(&o.ConfigFilePath, "config", o.WithDefaultOptions().ConfigFilePath, "Config file path")
+	cmd.Flags().StringVar(&o.StateFile, "state", o.WithDefaultOptions().StateFile, "Last successful push state file")
+	cmd.Flags().BoolVar(&o.Header, "header", o.Header, "Write the list source in a header comment")
+	cmd.Flags().BoolVar(&o.Verify, "verify", o.Verify, "Read back copied files before they replace the remote ones, hosts where they differ are not reloaded")
+	cmd.Flags().BoolVar(&o.Resync, "resync", o.Resync, "Push even if roles did not change since last push")
+	cmd.Flags().BoolVar(&o.Force, "force", o.Force, "Copy even if a safety guard fails or can't run, implies --resync")
 	return cmd
 }
+
+// AIOCmd lists roles, copies the user list to every host and reloads the
+// ones it updated.
+func AIOCmd(c *cobra.Command, o *options.Options, conf configuration.Configurations) error {
+	content, err := list.ListCmd(c, o, conf)
+	if err != nil {
+		return err
+	}
+
+	fingerprints, err := hostFingerprints(o, conf, content)
+	if err != nil {
+		return err
+	}
+
+	// Skip hosts when nothing they get changed since the last push
+	lastPush := state.NewStateFromFile(o.StateFile)
+	if skipPush(o, lastPush, fingerprints) {
+		log.Info("No changes in roles since last push, nothing to do")
+		return nil
+	}
+
+	// Hosts the copy updated are reloaded even when others failed, so are
+	// the ones whose last reload failed, over the SSH connections of the copy
+	results, clients, copyErr := copy.CopyKeepClients(c, o, conf)
+	defer clients.Close()
+	if results == nil {
+		return copyErr
+	}
+
+	reloads, reloadErr := reload.ReloadCopied(c, o, conf, results, lastPush.ReloadPending, clients)
+	if reloads == nil {
+		return reloadErr
+	}
+
+	for host, result := range results {
+		if result == copy.Failed {
+			continue
+		}
+
+		err, tried := reloads[host]
+		if !tried && lastPush.ReloadPending(host) {
+			// Not reloaded this time, still pending
+			continue
+		}
+
+		lastPush.Pushed(host, fingerprints[host])
+		if err != nil {
+			lastPush.ReloadFailed(host)
+		}
+	}
+
+	if err := lastPush.Save(); err != nil {
+		return err
+	}
+
+	if reloadErr != nil {
+		return reloadErr
+	}
+
+	return copyErr
+}
+
+// hostFingerprints returns by host the fingerprint of what is pushed there,
+// the generated content along with where and how it is written.
+func hostFingerprints(o *options.Options, conf configuration.Configurations, content string) (map[string]string, error) {
+	hostVars, err := conf.GetPGBouncerHost()
+	if err != nil {
+		return nil, err
+	}
+
+	generated, err := copy.GeneratedFiles(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	localPaths := []string{}
+	for localPath := range generated {
+		localPaths = append(localPaths, localPath)
+	}
+	sort.Strings(localPaths)
+
+	fingerprints := map[string]string{}
+	for _, pgHost := range hostVars {
+		perms, err := conf.GetFilePermissions(pgHost)
+		if err != nil {
+			return nil, err
+		}
+
+		fp := state.NewFingerprint()
+		fp.Add(content, pgHost.Host, strconv.FormatInt(pgHost.Port, 10), pgHost.Transport, strconv.FormatBool(pgHost.ManagedBlock))
+		fp.Add(o.DestinationFile)
+		for _, localPath := range localPaths {
+			fp.Add(localPath, generated[localPath])
+		}
+		fp.Add(perms.Owner, perms.Group, perms.Mode.String())
+
+		fingerprints[pgHost.Host] = fp.String()
+	}
+
+	return fingerprints, nil
+}
+
+// skipPush reports whether the push can be skipped, --resync and --force
+// always push.
+func skipPush(o *options.Options, lastPush state.States, fingerprints map[string]string) bool {
+	if o.Resync || o.Force {
+		return false
+	}
+
+	return unchanged(lastPush, fingerprints)
+}
+
+// unchanged reports whether every host already got and loaded its
+// fingerprint.
+func unchanged(lastPush state.States, fingerprints map[string]string) bool {
+	if len(fingerprints) == 0 {
+		return false
+	}
+
+	for host, fingerprint := range fingerprints {
+		if !lastPush.Unchanged(host, fingerprint) {
+			log.Info("Changes to push to host ", host)
+			return false
+		}
+
+		if lastPush.ReloadPending(host) {
+			log.Info("Last reload of host ", host, " failed")
+			return false
+		}
+	}
+
+	return true
+}
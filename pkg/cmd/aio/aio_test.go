@@ -0,0 +1,66 @@
+package aio
+
+import (
+	"path/filepath"
+	"testing"
+
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/cmd/options"
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/state"
+)
+
+func Test_skipPush(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.yaml")
+	saved := state.NewStateFromFile(path)
+	saved.Pushed("pgbouncer-01", "abc")
+	saved.Pushed("pgbouncer-02", "def")
+	saved.ReloadFailed("pgbouncer-02")
+	if err := saved.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		o            *options.Options
+		fingerprints map[string]string
+		want         bool
+	}{
+		{
+			name:         "Unchanged",
+			o:            &options.Options{},
+			fingerprints: map[string]string{"pgbouncer-01": "abc"},
+			want:         true,
+		},
+		{
+			name:         "Changed",
+			o:            &options.Options{},
+			fingerprints: map[string]string{"pgbouncer-01": "def"},
+		},
+		{
+			name:         "Reload pending",
+			o:            &options.Options{},
+			fingerprints: map[string]string{"pgbouncer-02": "def"},
+		},
+		{
+			name:         "No hosts",
+			o:            &options.Options{},
+			fingerprints: map[string]string{},
+		},
+		{
+			name:         "Resync",
+			o:            &options.Options{Resync: true},
+			fingerprints: map[string]string{"pgbouncer-01": "abc"},
+		},
+		{
+			name:         "Force",
+			o:            &options.Options{Force: true},
+			fingerprints: map[string]string{"pgbouncer-01": "abc"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := skipPush(tt.o, state.NewStateFromFile(path), tt.fingerprints); got != tt.want {
+				t.Errorf("skipPush() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package audit
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/audit"
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/cmd/options"
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/cmd/reload"
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/configuration"
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/databases"
+)
+
+const (
+	getApplicationExample = `
+	# Audit role passwords and print a table
+	%[1]s audit --config /etc/pgbouncer-updater/config.yaml
+
+	# Audit role passwords and print a JSON report
+	%[1]s audit --config /etc/pgbouncer-updater/config.yaml --output json
+	`
+
+	getUsage = `
+	Classify every role password verifier (md5, SCRAM-SHA-256, plaintext, empty, null),
+	flag superuser and replication roles exposed through PGBouncer and verifiers the
+	PGBouncer auth_type can't check. Exit with an error on policy violations.
+	`
+
+	showConfigQuery = "show config"
+)
+
+func NewCmdAudit(o *options.Options) *cobra.Command {
+	var output string
+
+	var cmd = &cobra.Command{
+		Use:          "pgbouncer-updater audit",
+		Short:        "Audit role password verifiers",
+		Long:         getUsage,
+		Aliases:      []string{"audit", "au"},
+		Example:      o.Exemple(getApplicationExample),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			// Get config from file
+			conf, err := configuration.NewConfigurationFromFile(o.ConfigFilePath)
+			if (err != nil) && err != configuration.FileNotFound {
+				return err
+			}
+
+			if err == configuration.FileNotFound {
+				conf = configuration.NewDefaultConfiguration(o.UserName, o.DBName, o.PGHost, o.Password, o.PGBouncerHosts...)
+			}
+
+			if output != "table" && output != "json" {
+				return fmt.Errorf("unknown output format %q, use table or json", output)
+			}
+
+			return AuditCmd(c, o, conf, output)
+		},
+	}
+
+	o.WithDefaultFlags(cmd)
+	cmd.Flags().StringVar(&o.Query, "query", o.WithDefaultOptions().Query, "Query to get Roles exported to PGBouncer")
+	cmd.Flags().StringVar(&o.ConfigFilePath, "config", o.WithDefaultOptions().ConfigFilePath, "Config file path")
+	cmd.Flags().StringVar(&output, "output", "table", "Report format, table or json")
+	return cmd
+}
+
+func AuditCmd(c *cobra.Command, o *options.Options, conf configuration.Configurations, output string) error {
+	policy, err := conf.GetAuditPolicy()
+	if err != nil {
+		return err
+	}
+
+	// Parse config to DSN Format
+	dsn, err := conf.GetPostgresDSN()
+	if err != nil {
+		return err
+	}
+
+	settings, err := conf.GetDatabaseSettings()
+	if err != nil {
+		return err
+	}
+
+	// Configure Postgres connection
+	db, err := databases.NewQuery(c.Context(), dsn, settings.QueryOptions()...)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// Roles selected by the export query end up in PGBouncer auth file
+	exposed := map[string]bool{}
+	err = db.Stream(c.Context(), o.Query, func(userName, _ string) error {
+		exposed[userName] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	roles, err := db.ToRecords(c.Context(), audit.Query)
+	if err != nil {
+		return err
+	}
+
+	report := audit.NewAudit(policy)
+	if err := withAuthTypes(c, conf, policy, report); err != nil {
+		return err
+	}
+
+	for _, record := range roles {
+		role := &audit.Role{
+			Name:        record["rolname"].String,
+			Verifier:    audit.Classify(record["rolpassword"].String, record["rolpassword"].Valid),
+			Superuser:   record["rolsuper"].String == "true",
+			Replication: record["rolreplication"].String == "true",
+			CanLogin:    record["rolcanlogin"].String == "true",
+		}
+		report.Add(role, exposed[role.Name])
+	}
+
+	switch output {
+	case "json":
+		err = report.WriteJSON(c.OutOrStdout())
+	default:
+		err = report.WriteTable(c.OutOrStdout())
+	}
+	if err != nil {
+		return err
+	}
+
+	if n := report.Violations(); n > 0 {
+		return fmt.Errorf("%d role(s) violate the audit policy", n)
+	}
+
+	return nil
+}
+
+// withAuthTypes reads auth_type from the policy or else from every
+// PGBouncer host admin console.
+func withAuthTypes(c *cobra.Command, conf configuration.Configurations, policy *audit.Policy, report audit.Audits) error {
+	if policy.AuthType != "" {
+		report.WithAuthType("config", policy.AuthType)
+		return nil
+	}
+
+	hostVars, err := conf.GetPGBouncerHost()
+	if err != nil {
+		return err
+	}
+
+	for _, hostvar := range hostVars {
+		pgHost := hostvar.DeepCopy()
+
+		authType, err := showAuthType(c, conf, pgHost)
+		if err != nil {
+			log.Warn("Failed to read auth_type from PGBouncer host ", pgHost.Host, ": ", err)
+			continue
+		}
+		report.WithAuthType(pgHost.Host, authType)
+	}
+
+	return nil
+}
+
+func showAuthType(c *cobra.Command, conf configuration.Configurations, pgHost *configuration.PGBouncerHost) (string, error) {
+	db, err := reload.NewAdminConsole(c.Context(), conf, pgHost, nil)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	records, err := db.ToRecords(c.Context(), showConfigQuery)
+	if err != nil {
+		return "", err
+	}
+
+	for _, record := range records {
+		if record["key"].String == "auth_type" {
+			return record["value"].String, nil
+		}
+	}
+
+	return "", fmt.Errorf("auth_type not found in %s", showConfigQuery)
+}
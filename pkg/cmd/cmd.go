@@ -3,11 +3,15 @@ package cmd
 import (
 	"github.com/spf13/cobra"
 	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/cmd/aio"
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/cmd/audit"
 	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/cmd/config"
 	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/cmd/copy"
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/cmd/diff"
 	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/cmd/list"
 	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/cmd/options"
 	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/cmd/reload"
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/cmd/status"
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/cmd/watch"
 )
 
 const (
@@ -23,6 +27,18 @@ const (
 
 	# Reload PGBouncer hosts
 	%[1]s reload
+
+	# Sync PGBouncer hosts on role change notifications
+	%[1]s watch
+
+	# Audit role password verifiers
+	%[1]s audit
+
+	# Compare the source database roles with a PGBouncer host
+	%[1]s diff db host:pgbouncer-01
+
+	# Check owner and mode of the files pushed to PGBouncer hosts
+	%[1]s status
  `
 )
 
@@ -41,11 +57,15 @@ func NewCmdPGBouncerUpdate(o *options.Options) *cobra.Command {
 
 	cmd.AddCommand(config.NewCmdConfig(o))
 	cmd.AddCommand(aio.NewCmdAIO(o))
+	cmd.AddCommand(watch.NewCmdWatch(o))
 
 	//WARN reload func must be called
 	// before all other func use options query vars
 	cmd.AddCommand(reload.NewCmdReload(o))
 	cmd.AddCommand(list.NewCmdUpdateUserList(o))
+	cmd.AddCommand(audit.NewCmdAudit(o))
+	cmd.AddCommand(diff.NewCmdDiff(o))
+	cmd.AddCommand(status.NewCmdStatus(o))
 	cmd.AddCommand(copy.NewCmdCopyUserList(o))
 
 	return cmd
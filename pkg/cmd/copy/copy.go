@@ -1,6 +1,8 @@
 package copy
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -10,7 +12,9 @@ import (
 	"github.com/spf13/cobra"
 	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/cmd/options"
 	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/configuration"
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/guard"
 	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/sendfile"
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/userlist"
 )
 
 const (
@@ -26,6 +30,9 @@ const (
 
 	# Copy user list from default to server as suoder
 	%[1]s copy -sudo
+
+	# Copy user list and check what landed on the hosts
+	%[1]s copy -verify
 	`
 
 	getUsage = `
@@ -36,6 +43,45 @@ const (
 	DefaultUserlistOldPath    = "userlist.txt.old"
 )
 
+// Result is the outcome of a copy on a host.
+type Result int
+
+const (
+	// Every file on the host was already up to date
+	Unchanged Result = iota
+	// At least one file was copied
+	Updated
+	// A file could not be checked or copied, the host may be left with
+	// part of the files
+	Failed
+)
+
+// Results of a copy by PGBouncer host.
+type Results map[string]Result
+
+// Clients are the SSH clients of a copy by PGBouncer host, kept open for
+// the reload.
+type Clients map[string]sendfile.Scp
+
+func (c Clients) Close() {
+	for _, client := range c {
+		client.Close()
+	}
+}
+
+func (r Result) String() string {
+	switch r {
+	case Unchanged:
+		return "unchanged"
+	case Updated:
+		return "updated"
+	case Failed:
+		return "failed"
+	}
+
+	return fmt.Sprintf("Result(%d)", int(r))
+}
+
 func NewCmdCopyUserList(o *options.Options) *cobra.Command {
 
 	var cmd = &cobra.Command{
@@ -56,7 +102,7 @@ func NewCmdCopyUserList(o *options.Options) *cobra.Command {
 				conf = configuration.NewDefaultConfiguration(o.UserName, o.DBName, o.PGHost, o.Password, o.PGBouncerHosts...)
 			}
 
-			if err := CopyCmd(c, o, conf); err != nil {
+			if _, err := CopyCmd(c, o, conf); err != nil {
 				return err
 			}
 
@@ -68,22 +114,52 @@ func NewCmdCopyUserList(o *options.Options) *cobra.Command {
 	cmd.Flags().BoolVar(&o.Sudo, "sudo", o.Sudo, "Copy file as sudoer")
 	cmd.Flags().StringVar(&o.ConfigFilePath, "config", "/etc/pgboncer-updater/config.yaml", "Config file path")
 	cmd.Flags().StringVar(&o.File, "file", "userlist.txt", "User list file")
+	cmd.Flags().BoolVar(&o.Force, "force", o.Force, "Copy even if a safety guard fails or can't run")
+	cmd.Flags().BoolVar(&o.Verify, "verify", o.Verify, "Read back the checksum and size of copied files before they replace the remote ones")
 	cmd.Flags().StringVar(&o.DestinationFile, "remote", "/etc/pgbouncer/userlist.txt", "Remote users list file path")
 	return cmd
 }
 
-func CopyCmd(c *cobra.Command, o *options.Options, conf configuration.Configurations) error {
+// CopyCmd copies the userlist and generated files to every host. Results
+// holds the outcome of every host, the error is the one of the first failed
+// host.
+func CopyCmd(c *cobra.Command, o *options.Options, conf configuration.Configurations) (Results, error) {
+	results, clients, err := CopyKeepClients(c, o, conf)
+	clients.Close()
+	return results, err
+}
+
+// CopyKeepClients copies like CopyCmd and returns the SSH client of every
+// host open, the caller closes them.
+func CopyKeepClients(c *cobra.Command, o *options.Options, conf configuration.Configurations) (Results, Clients, error) {
 	hostVars, err := conf.GetPGBouncerHost()
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	if len(hostVars) <= 0 {
-		return fmt.Errorf("no hosts to copy userlists")
+		return nil, nil, fmt.Errorf("no hosts to copy userlists")
+	}
+
+	policy, err := conf.GetGuardPolicy()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	generated, err := GeneratedFiles(conf)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	type hostResult struct {
+		host   string
+		result Result
+		client sendfile.Scp
+		err    error
 	}
 
 	wg := sync.WaitGroup{}
-	errCh := make(chan error, len(hostVars))
+	resultCh := make(chan hostResult, len(hostVars))
 	log.Info("Start copying userlist to hosts")
 	for _, hostvar := range hostVars {
 		host := hostvar.DeepCopy()
@@ -91,56 +167,233 @@ func CopyCmd(c *cobra.Command, o *options.Options, conf configuration.Configurat
 
 		wg.Add(1)
 		go func(pgHost *configuration.PGBouncerHost) {
-			log.Info("Connect to ", pgHost.Host)
-			host := fmt.Sprintf("%s:%d", pgHost.Host, pgHost.Port)
-			scp := sendfile.NewScpClient(host, pgHost.UserName, pgHost.Port, []byte(pgHost.PrivKey), o.Sudo)
 			defer wg.Done()
 
-			log.Info("Save current userlist to ", DefaultUserlistOldPath)
-			if err := scp.SaveOld(c.Context(), DefaultUserlistOldPath, DefaultUserlistRemotePath); err != nil {
-				log.Error(err)
-				errCh <- err
-				return
-			}
-			readers, err := openFiles(DefaultUserlistOldPath, o.File)
+			client, err := newClient(o, conf, pgHost)
 			if err != nil {
-				log.Error(err)
-				errCh <- err
+				resultCh <- hostResult{pgHost.Host, Failed, nil, err}
 				return
-
 			}
-			log.Info("Compare userlist between ", DefaultUserlistOldPath, " and ", o.File)
-			if err := scp.CompareFiles(readers["old"], readers["new"]); err != nil {
-				if err != sendfile.ErrorDiff {
-					errCh <- err
-					return
-				}
-
-				log.Info("Copy new userlist to ", pgHost.Host)
-				if err := scp.Copy(c.Context(), o.File, o.DestinationFile); err != nil {
-					log.Error(err)
-					errCh <- err
-					return
-				}
 
-				return
+			updated, err := copyHost(c.Context(), o, policy, generated, client, pgHost)
+			switch {
+			case err != nil:
+				resultCh <- hostResult{pgHost.Host, Failed, client, err}
+			case updated:
+				resultCh <- hostResult{pgHost.Host, Updated, client, nil}
+			default:
+				resultCh <- hostResult{pgHost.Host, Unchanged, client, nil}
 			}
-
-			log.Info("No changes found in userlist for host ", pgHost.Host)
 		}(host)
 	}
 	wg.Wait()
 
-	close(errCh)
-	for err := range errCh {
+	close(resultCh)
+	results := Results{}
+	clients := Clients{}
+	var firstErr error
+	for res := range resultCh {
+		log.Info("Copy to ", res.host, ": ", res.result)
+		results[res.host] = res.result
+		if res.client != nil {
+			clients[res.host] = res.client
+		}
+		if res.err != nil && firstErr == nil {
+			firstErr = res.err
+		}
+	}
+
+	return results, clients, firstErr
+}
+
+// newClient returns the client copying files to pgHost with their
+// permissions.
+func newClient(o *options.Options, conf configuration.Configurations, pgHost *configuration.PGBouncerHost) (sendfile.Scp, error) {
+	perms, err := conf.GetFilePermissions(pgHost)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info("Connect to ", pgHost.Host)
+	scp, err := pgHost.NewClient(o.Sudo)
+	if err != nil {
+		return nil, err
+	}
+
+	return scp.WithPermissions(perms).WithVerify(o.Verify), nil
+}
+
+// copyHost copies the userlist and generated files to pgHost, updated is
+// set when any of them was copied.
+func copyHost(ctx context.Context, o *options.Options, policy *guard.Policy, generated map[string]string, scp sendfile.Scp, pgHost *configuration.PGBouncerHost) (bool, error) {
+	updated, err := copyUserlist(ctx, o, policy, scp, pgHost)
+	if err != nil {
+		return false, err
+	}
+
+	for localPath, remotePath := range generated {
+		copied, err := copyGenerated(ctx, o, scp, pgHost, localPath, remotePath)
 		if err != nil {
+			return false, err
+		}
+		updated = updated || copied
+	}
+
+	return updated, nil
+}
+
+// copyUserlist checks the generated userlist against the one on the host
+// and copies it when it changed.
+func copyUserlist(ctx context.Context, o *options.Options, policy *guard.Policy, scp sendfile.Scp, pgHost *configuration.PGBouncerHost) (bool, error) {
+	// A managed block depends on the remote file, it must be downloaded
+	if !pgHost.ManagedBlock && sameChecksum(ctx, scp, pgHost, o.File, o.DestinationFile) {
+		log.Info("No changes found in ", o.DestinationFile, " for host ", pgHost.Host)
+		return false, nil
+	}
+
+	// Hosts run concurrently, each one needs its own backup
+	oldPath := fmt.Sprintf("%s.%s", DefaultUserlistOldPath, pgHost.Host)
+	log.Info("Save current userlist to ", oldPath)
+	if err := scp.SaveOld(ctx, oldPath, o.DestinationFile); err != nil {
+		log.Error(err)
+		return false, err
+	}
+
+	newPath := o.File
+	if pgHost.ManagedBlock {
+		// The managed block is merged into this host's own file
+		newPath = fmt.Sprintf("%s.%s", o.File, pgHost.Host)
+		log.Info("Replace managed block of ", oldPath, " into ", newPath)
+		if err := replaceBlock(oldPath, o.File, newPath); err != nil {
+			log.Error("Refuse to copy userlist to ", pgHost.Host, ": ", err)
+			return false, err
+		}
+	}
+
+	// Guards fail closed, a list they can't read blocks the copy as well
+	if err := checkFiles(policy, oldPath, newPath); err != nil {
+		if !o.Force {
+			log.Error("Refuse to copy userlist to ", pgHost.Host, ": ", err)
+			return false, err
+		}
+		log.Warn("Copy userlist to ", pgHost.Host, " despite: ", err)
+	}
+
+	return copyIfChanged(ctx, o, scp, pgHost, oldPath, newPath)
+}
+
+// GeneratedFiles returns the remote path of the configured pgbouncer.ini
+// fragments and hba file by local path.
+func GeneratedFiles(conf configuration.Configurations) (map[string]string, error) {
+	generated := map[string]string{}
+
+	dbs, err := conf.GetPGBouncerDBs()
+	if err != nil {
+		return nil, err
+	}
+	if dbs != nil {
+		generated[dbs.File] = dbs.Remote
+	}
+
+	users, err := conf.GetPGBouncerUsers()
+	if err != nil {
+		return nil, err
+	}
+	if users != nil {
+		generated[users.File] = users.Remote
+	}
+
+	hba, err := conf.GetHBASettings()
+	if err != nil {
+		return nil, err
+	}
+	if hba != nil {
+		generated[hba.File] = hba.Remote
+	}
+
+	return generated, nil
+}
+
+// copyGenerated copies a generated file when its checksum differs from the
+// remote one or the remote file is missing.
+func copyGenerated(ctx context.Context, o *options.Options, scp sendfile.Scp, pgHost *configuration.PGBouncerHost, localPath, remotePath string) (bool, error) {
+	if sameChecksum(ctx, scp, pgHost, localPath, remotePath) {
+		log.Info("No changes found in ", remotePath, " for host ", pgHost.Host)
+		return false, nil
+	}
+
+	if err := copyFile(ctx, o, scp, pgHost, localPath, remotePath); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// copyFile copies localPath to the host, it is read back before replacing
+// the remote file when verify is set.
+func copyFile(ctx context.Context, o *options.Options, scp sendfile.Scp, pgHost *configuration.PGBouncerHost, localPath, remotePath string) error {
+	log.Info("Copy ", localPath, " to ", pgHost.Host, ":", remotePath)
+	if err := scp.Copy(ctx, localPath, remotePath); err != nil {
+		if errors.Is(err, sendfile.ErrVerify) {
+			log.Error("Failed to verify ", remotePath, " on ", pgHost.Host, ", kept the old one: ", err)
 			return err
 		}
+		log.Error(err)
+		return err
+	}
+
+	if o.Verify {
+		log.Info("Verified ", remotePath, " on ", pgHost.Host)
 	}
 
 	return nil
 }
 
+// sameChecksum tells whether the remote file already holds localPath
+// without downloading it, errors count as a difference.
+func sameChecksum(ctx context.Context, scp sendfile.Scp, pgHost *configuration.PGBouncerHost, localPath, remotePath string) bool {
+	local, err := sendfile.LocalChecksum(localPath)
+	if err != nil {
+		return false
+	}
+
+	remote, err := scp.RemoteChecksum(ctx, remotePath)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Warn("Failed to checksum ", remotePath, " on ", pgHost.Host, ": ", err)
+		}
+		return false
+	}
+
+	return local == remote
+}
+
+func copyIfChanged(ctx context.Context, o *options.Options, scp sendfile.Scp, pgHost *configuration.PGBouncerHost, oldPath, newPath string) (bool, error) {
+	remotePath := o.DestinationFile
+
+	readers, err := openFiles(oldPath, newPath)
+	if err != nil {
+		log.Error(err)
+		return false, err
+	}
+
+	log.Info("Compare ", oldPath, " and ", newPath)
+	if err := scp.CompareFiles(userlist.WithoutHeader(readers["old"]), userlist.WithoutHeader(readers["new"])); err != nil {
+		if err != sendfile.ErrorDiff {
+			return false, err
+		}
+
+		if err := copyFile(ctx, o, scp, pgHost, newPath, remotePath); err != nil {
+			return false, err
+		}
+
+		return true, nil
+	}
+
+	log.Info("No changes found in ", remotePath, " for host ", pgHost.Host)
+	return false, nil
+}
+
 func openFiles(old, new string) (map[string]io.Reader, error) {
 
 	oldReader, err := os.Open(old)
@@ -158,3 +411,62 @@ func openFiles(old, new string) (map[string]io.Reader, error) {
 		"new": newReader,
 	}, nil
 }
+
+// checkFiles logs the role changes between two auth files and checks them
+// against the guards. When the current file can't be parsed the guards on
+// the new list alone still run, the parse error is returned when a removal
+// guard is configured.
+func checkFiles(policy *guard.Policy, old, new string) error {
+	newUsers, err := userlist.ParseFile(new)
+	if err != nil {
+		return err
+	}
+
+	oldUsers, oldErr := userlist.ParseFile(old)
+	if oldErr != nil {
+		oldUsers = nil
+	} else {
+		log.Info("Userlist changes from ", old, ": ", userlist.Diff(oldUsers, newUsers))
+	}
+
+	if err := guard.NewGuard(policy).Check(oldUsers, newUsers); err != nil {
+		return err
+	}
+
+	if oldErr != nil {
+		if policy.ChecksRemovals() {
+			return fmt.Errorf("removal guards not checked: %w", oldErr)
+		}
+		log.Warn("Replace ", old, " without reading it: ", oldErr)
+	}
+
+	return nil
+}
+
+// replaceBlock writes to dst the current remote file with its managed block
+// replaced by the generated userlist.
+func replaceBlock(current, generated, dst string) error {
+	currentReader, err := os.Open(current)
+	if err != nil {
+		return err
+	}
+	defer currentReader.Close()
+
+	generatedReader, err := os.Open(generated)
+	if err != nil {
+		return err
+	}
+	defer generatedReader.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	if err := userlist.ReplaceBlock(out, currentReader, generatedReader); err != nil {
+		out.Close()
+		return fmt.Errorf("%s: %w", current, err)
+	}
+
+	return out.Close()
+}
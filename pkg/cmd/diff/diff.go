@@ -0,0 +1,187 @@
+package diff
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/cmd/list"
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/cmd/options"
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/configuration"
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/databases"
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/userlist"
+)
+
+const (
+	getApplicationExample = `
+	# Compare the source database roles with a PGBouncer host auth file
+	%[1]s diff db host:pgbouncer-01
+
+	# Compare two PGBouncer hosts
+	%[1]s diff host:pgbouncer-01 host:pgbouncer-02 --sudo
+
+	# Compare a local file with the source database
+	%[1]s diff file:/tmp/userlist.txt db
+	`
+
+	getUsage = `
+	Print the roles added, removed or changed from the first source to the second one,
+	verifiers are masked. Exit with an error when sources differ.
+
+	Sources are:
+	  db          roles returned by the query on the source database
+	  file:PATH   a local auth file
+	  host:NAME   the auth file of a PGBouncer host from the config
+	`
+
+	sourceDB   = "db"
+	sourceFile = "file:"
+	sourceHost = "host:"
+)
+
+var (
+	ErrDiffer = errors.New("user lists differ")
+)
+
+func NewCmdDiff(o *options.Options) *cobra.Command {
+
+	var cmd = &cobra.Command{
+		Use:          "pgbouncer-updater diff SOURCE SOURCE",
+		Short:        "Compare user lists",
+		Long:         getUsage,
+		Aliases:      []string{"diff", "df"},
+		Example:      o.Exemple(getApplicationExample),
+		Args:         cobra.ExactArgs(2),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			// Get config from file
+			conf, err := configuration.NewConfigurationFromFile(o.ConfigFilePath)
+			if (err != nil) && err != configuration.FileNotFound {
+				return err
+			}
+
+			if err == configuration.FileNotFound {
+				conf = configuration.NewDefaultConfiguration(o.UserName, o.DBName, o.PGHost, o.Password, o.PGBouncerHosts...)
+			}
+
+			return DiffCmd(c, o, conf, args[0], args[1])
+		},
+	}
+
+	o.WithDefaultFlags(cmd)
+	cmd.Flags().BoolVar(&o.Sudo, "sudo", o.Sudo, "Read remote file as sudoer")
+	cmd.Flags().StringVar(&o.Query, "query", o.WithDefaultOptions().Query, "Query to get Roles from DB")
+	cmd.Flags().StringVar(&o.ConfigFilePath, "config", o.WithDefaultOptions().ConfigFilePath, "Config file path")
+	cmd.Flags().StringVar(&o.DestinationFile, "remote", o.WithDefaultOptions().DestinationFile, "Remote users list file path")
+	return cmd
+}
+
+func DiffCmd(c *cobra.Command, o *options.Options, conf configuration.Configurations, from, to string) error {
+	old, err := load(c, o, conf, from)
+	if err != nil {
+		return fmt.Errorf("%s: %w", from, err)
+	}
+
+	new, err := load(c, o, conf, to)
+	if err != nil {
+		return fmt.Errorf("%s: %w", to, err)
+	}
+
+	fmt.Fprintf(c.OutOrStdout(), "--- %s\n+++ %s\n", from, to)
+	changes, err := userlist.WriteDiff(c.OutOrStdout(), old, new)
+	if err != nil {
+		return err
+	}
+
+	if !changes.Empty() {
+		return fmt.Errorf("%w: %s", ErrDiffer, changes)
+	}
+
+	return nil
+}
+
+func load(c *cobra.Command, o *options.Options, conf configuration.Configurations, source string) ([]*userlist.User, error) {
+	switch {
+	case source == sourceDB:
+		return loadDB(c, o, conf)
+	case strings.HasPrefix(source, sourceFile):
+		return userlist.ParseFile(strings.TrimPrefix(source, sourceFile))
+	case strings.HasPrefix(source, sourceHost):
+		return loadHost(c, o, conf, strings.TrimPrefix(source, sourceHost))
+	default:
+		return nil, fmt.Errorf("unknown source, use %s, %sPATH or %sNAME", sourceDB, sourceFile, sourceHost)
+	}
+}
+
+func loadDB(c *cobra.Command, o *options.Options, conf configuration.Configurations) ([]*userlist.User, error) {
+	dsn, err := conf.GetPostgresDSN()
+	if err != nil {
+		return nil, err
+	}
+
+	settings, err := conf.GetDatabaseSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := databases.NewQuery(c.Context(), dsn, settings.QueryOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	// Render the list the way list does, extra users included
+	buf := new(bytes.Buffer)
+	users, err := list.WithExtraUsers(conf, userlist.NewUsersWriter(buf))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Stream(c.Context(), o.Query, users.Write); err != nil {
+		return nil, err
+	}
+
+	if err := users.Close(); err != nil {
+		return nil, err
+	}
+
+	return userlist.Parse(buf)
+}
+
+func loadHost(c *cobra.Command, o *options.Options, conf configuration.Configurations, name string) ([]*userlist.User, error) {
+	hostVars, err := conf.GetPGBouncerHost()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, hostvar := range hostVars {
+		if hostvar.Host != name {
+			continue
+		}
+
+		pgHost := hostvar.DeepCopy()
+		scp, err := pgHost.NewClient(o.Sudo)
+		if err != nil {
+			return nil, err
+		}
+		defer scp.Close()
+
+		f, err := os.CreateTemp("", "userlist-*.txt")
+		if err != nil {
+			return nil, err
+		}
+		f.Close()
+		defer os.Remove(f.Name())
+
+		if err := scp.SaveOld(c.Context(), f.Name(), o.DestinationFile); err != nil {
+			return nil, err
+		}
+
+		return userlist.ParseFile(f.Name())
+	}
+
+	return nil, fmt.Errorf("host not found in config")
+}
@@ -1,10 +1,20 @@
 package list
 
 import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/cmd/options"
 	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/configuration"
 	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/databases"
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/hba"
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/pgbouncerini"
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/state"
 	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/userlist"
 )
 
@@ -45,7 +55,7 @@ func NewCmdUpdateUserList(o *options.Options) *cobra.Command {
 				conf = configuration.NewDefaultConfiguration(o.UserName, o.DBName, o.PGHost, o.Password, o.PGBouncerHosts...)
 			}
 
-			if err := ListCmd(c, o, conf); err != nil {
+			if _, err := ListCmd(c, o, conf); err != nil {
 				return err
 			}
 
@@ -57,40 +67,245 @@ func NewCmdUpdateUserList(o *options.Options) *cobra.Command {
 	cmd.Flags().StringVar(&o.Query, "query", o.WithDefaultOptions().Query, "Query to get Roles from DB")
 	cmd.Flags().StringVar(&o.ConfigFilePath, "config", o.WithDefaultOptions().ConfigFilePath, "Config file path")
 	cmd.Flags().StringVar(&o.File, "file", o.WithDefaultOptions().File, "USer list file")
+	cmd.Flags().BoolVar(&o.Header, "header", o.Header, "Write the list source in a header comment")
 	return cmd
 }
 
-func ListCmd(c *cobra.Command, o *options.Options, conf configuration.Configurations) error {
+// ListCmd writes the role list to o.File and returns the role set
+// fingerprint used to detect changes between two runs.
+func ListCmd(c *cobra.Command, o *options.Options, conf configuration.Configurations) (string, error) {
 
 	// Parse config to DSN Format
 	dsn, err := conf.GetPostgresDSN()
 	if err != nil {
-		return err
+		return "", err
+	}
+
+	settings, err := conf.GetDatabaseSettings()
+	if err != nil {
+		return "", err
 	}
 
 	// Configure Postgres connection
-	db, err := databases.NewQuery(dsn)
+	db, err := databases.NewQuery(c.Context(), dsn, settings.QueryOptions()...)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer db.Close()
 
-	// Exec query to map
-	data, err := db.ToMap(o.Query)
+	// Fingerprint what is written, extra users included
+	fingerprint := state.NewFingerprint()
+	err = replaceFile(o.File, func(w io.Writer) error {
+		list, err := WithExtraUsers(conf, &fingerprintWriter{UsersWriter: userlist.NewUsersWriter(w), fingerprint: fingerprint})
+		if err != nil {
+			return err
+		}
+		defer list.Close()
+
+		if o.Header {
+			source, err := conf.GetPostgresSource()
+			if err != nil {
+				return err
+			}
+
+			if err := list.WriteHeader(source); err != nil {
+				return err
+			}
+		}
+
+		// Stream rows to the file, rows come sorted by the query
+		if err := db.Stream(c.Context(), o.Query, list.Write); err != nil {
+			return err
+		}
+
+		return list.Close()
+	})
 	if err != nil {
+		return "", err
+	}
+
+	if err := listDatabases(c, db, conf, fingerprint); err != nil {
+		return "", err
+	}
+
+	if err := listUsers(c, db, conf, fingerprint); err != nil {
+		return "", err
+	}
+
+	if err := listHBA(c, db, conf, fingerprint); err != nil {
+		return "", err
+	}
+	return fingerprint.String(), nil
+}
+
+// listDatabases writes the [databases] fragment when the config asks for it.
+func listDatabases(c *cobra.Command, db databases.Databases, conf configuration.Configurations, fingerprint *state.Fingerprint) error {
+	dbs, err := conf.GetPGBouncerDBs()
+	if err != nil || dbs == nil {
 		return err
 	}
 
-	// Configure file
-	list, err := userlist.NewUserListToFile(o.File)
+	records, err := db.ToRecords(c.Context(), pgbouncerini.DatabasesQuery)
 	if err != nil {
 		return err
 	}
 
-	// Write data to a file
-	err = list.WriteMany(data)
+	section := pgbouncerini.NewDatabasesSection(&dbs.DatabaseRules)
+	for _, record := range records {
+		if _, err := section.Add(record["datname"].String); err != nil {
+			if !errors.Is(err, pgbouncerini.ErrInvalidName) {
+				return err
+			}
+			log.Warn("Skip database: ", err)
+		}
+	}
+
+	content := new(bytes.Buffer)
+	if err := section.Write(content); err != nil {
+		return err
+	}
+	fingerprint.Add(dbs.File, content.String())
+
+	log.Info("Write databases to ", dbs.File)
+	return replaceFile(dbs.File, func(w io.Writer) error {
+		_, err := w.Write(content.Bytes())
+		return err
+	})
+}
+
+// listUsers writes the [users] fragment when the config asks for it.
+func listUsers(c *cobra.Command, db databases.Databases, conf configuration.Configurations, fingerprint *state.Fingerprint) error {
+	users, err := conf.GetPGBouncerUsers()
+	if err != nil || users == nil {
+		return err
+	}
+
+	section := pgbouncerini.NewUsersSection()
+	if users.FromComments {
+		records, err := db.ToRecords(c.Context(), pgbouncerini.UsersQuery)
+		if err != nil {
+			return err
+		}
+
+		for _, record := range records {
+			userName := record["rolname"].String
+			settings, found, err := pgbouncerini.ParseComment(record["comment"].String)
+			if err != nil {
+				log.Warn("Skip comment of role ", userName, ": ", err)
+				continue
+			}
+
+			if !found {
+				continue
+			}
+
+			if err := section.Set(userName, settings); err != nil {
+				log.Warn("Skip role: ", err)
+			}
+		}
+	}
+
+	for userName, settings := range users.Users {
+		if err := section.Set(userName, settings); err != nil {
+			return err
+		}
+	}
+
+	content := new(bytes.Buffer)
+	if err := section.Write(content); err != nil {
+		return err
+	}
+	fingerprint.Add(users.File, content.String())
+
+	log.Info("Write users settings to ", users.File)
+	return replaceFile(users.File, func(w io.Writer) error {
+		_, err := w.Write(content.Bytes())
+		return err
+	})
+}
+
+// listHBA writes the auth_hba_file when the config asks for it.
+func listHBA(c *cobra.Command, db databases.Databases, conf configuration.Configurations, fingerprint *state.Fingerprint) error {
+	settings, err := conf.GetHBASettings()
+	if err != nil || settings == nil {
+		return err
+	}
+
+	file := hba.NewFile(settings.Rules)
+	if file.NeedMembers() {
+		records, err := db.ToRecords(c.Context(), hba.MembersQuery)
+		if err != nil {
+			return err
+		}
+
+		for _, record := range records {
+			file.AddMember(record["role"].String, record["member"].String)
+		}
+	}
+
+	content := new(bytes.Buffer)
+	if err := file.Write(content); err != nil {
+		return err
+	}
+	fingerprint.Add(settings.File, content.String())
+
+	log.Info("Write hba rules to ", settings.File)
+	return replaceFile(settings.File, func(w io.Writer) error {
+		_, err := w.Write(content.Bytes())
+		return err
+	})
+}
+
+// replaceFile writes filePath through a temp file renamed over it once
+// write succeeded, a query failing midway never leaves a truncated file.
+func replaceFile(filePath string, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".*.tmp")
 	if err != nil {
 		return err
 	}
-	return nil
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filePath)
+}
+
+// WithExtraUsers merges the configured extra users into the list, as
+// written to the hosts.
+func WithExtraUsers(conf configuration.Configurations, list userlist.UsersWriter) (userlist.UsersWriter, error) {
+	extraUsers, err := conf.GetExtraUsers()
+	if err != nil || extraUsers == nil {
+		return list, err
+	}
+
+	extras, err := extraUsers.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	return userlist.NewMergeWriter(list, extras, extraUsers.Precedence, func(userName string) {
+		log.Warn("Extra user ", userName, " shadows a database role, keep the ", extraUsers.Precedence, " one")
+	})
+}
+
+type fingerprintWriter struct {
+	userlist.UsersWriter
+	fingerprint *state.Fingerprint
+}
+
+func (f *fingerprintWriter) Write(userName, md5 string) error {
+	f.fingerprint.Add(userName, md5)
+	return f.UsersWriter.Write(userName, md5)
 }
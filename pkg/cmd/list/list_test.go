@@ -0,0 +1,62 @@
+package list
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_replaceFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		write   func(w io.Writer) error
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "Written",
+			write: func(w io.Writer) error {
+				_, err := io.WriteString(w, "\"app\" \"md5new\"\n")
+				return err
+			},
+			want: "\"app\" \"md5new\"\n",
+		},
+		{
+			name: "Failed midway",
+			write: func(w io.Writer) error {
+				io.WriteString(w, "\"app\" \"md5")
+				return errors.New("query timeout")
+			},
+			want:    "\"app\" \"md5old\"\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			filePath := filepath.Join(dir, "userlist.txt")
+			if err := os.WriteFile(filePath, []byte("\"app\" \"md5old\"\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := replaceFile(filePath, tt.write); (err != nil) != tt.wantErr {
+				t.Errorf("replaceFile() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if got, _ := os.ReadFile(filePath); string(got) != tt.want {
+				t.Errorf("replaceFile() left %q, want %q", got, tt.want)
+			}
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 {
+				t.Errorf("replaceFile() left %d files, want only the list", len(entries))
+			}
+		})
+	}
+}
@@ -22,6 +22,8 @@ type GetOptions interface {
 
 const (
 	cliName = "pgbouncer-updater"
+
+	DefaultReloadQuery = "reload"
 )
 
 type Options struct {
@@ -37,6 +39,17 @@ type Options struct {
 	DBName          string
 	Password        string
 	PGBouncerHosts  []string
+	Force           bool
+	StateFile       string
+	Header          bool
+	// Push even if roles did not change since last push, Force implies it
+	Resync bool
+	// Admin console query of reload, apart from Query which every command
+	// listing roles sets
+	ReloadQuery string
+	// Read back the checksum and size of copied files before they replace
+	// the remote ones
+	Verify bool
 }
 
 func NewPGBouncerUpdaterOptions() GetOptions {
@@ -56,8 +69,10 @@ func (o *Options) WithDefaultOptions() *Options {
 		Sudo:            false,
 		DestinationFile: "/etc/pgbouncer/userlist.txt",
 		Query:           databases.DefaultQuery,
+		ReloadQuery:     DefaultReloadQuery,
 		ConfigFilePath:  "/etc/pgbouncer-updater/config.yaml",
 		File:            "/tmp/userlist.txt",
+		StateFile:       "/tmp/pgbouncer-updater.state",
 	}
 
 	return o.newPGBouncerUpdaterOptions()
@@ -100,6 +115,8 @@ func (o *Options) newPGBouncerUpdaterOptions() *Options {
 		Sudo:            o.Sudo,
 		ConfigFilePath:  o.ConfigFilePath,
 		File:            o.File,
+		StateFile:       o.StateFile,
+		ReloadQuery:     o.ReloadQuery,
 	}
 
 	return defaultOpts
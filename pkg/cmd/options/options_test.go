@@ -24,6 +24,8 @@ func TestWithDefaultOptions(t *testing.T) {
 				DestinationFile: "/etc/pgbouncer/userlist.txt",
 				ConfigFilePath:  "/etc/pgbouncer-updater/config.yaml",
 				File:            "/tmp/userlist.txt",
+				StateFile:       "/tmp/pgbouncer-updater.state",
+				ReloadQuery:     "reload",
 				Sudo:            false,
 			},
 		},
@@ -49,6 +51,12 @@ func TestWithDefaultOptions(t *testing.T) {
 			if tt.want.File != got.File {
 				t.Errorf("WithDefaultOptions() = %v, want %v", got, tt.want)
 			}
+			if tt.want.StateFile != got.StateFile {
+				t.Errorf("WithDefaultOptions() = %v, want %v", got, tt.want)
+			}
+			if tt.want.ReloadQuery != got.ReloadQuery {
+				t.Errorf("WithDefaultOptions() = %v, want %v", got, tt.want)
+			}
 		})
 	}
 }
@@ -1,13 +1,17 @@
 package reload
 
 import (
+	"context"
+	"fmt"
 	"sync"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/cmd/copy"
 	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/cmd/options"
 	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/configuration"
 	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/databases"
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/sendfile"
 )
 
 const (
@@ -23,13 +27,16 @@ const (
 	`
 
 	getUsage = `
-	Exec reload query in PGBouncer DB
+	Exec reload query in PGBouncer DB, through an SSH tunnel to the host when
+	its reload settings enable it. Hosts with another reload method run
+	systemctl reload, kill -HUP or docker kill -s HUP through sudo, or a custom
+	command, over SSH instead. aio reloads over the SSH connections its copy
+	opened
 	`
 )
 
 const (
-	defaultPGBouncerDB  = "postgres"
-	defaultPostgresPort = 5432
+	defaultPGBouncerDB = "postgres"
 )
 
 func NewCmdReload(o *options.Options) *cobra.Command {
@@ -64,62 +71,152 @@ func NewCmdReload(o *options.Options) *cobra.Command {
 
 	o.WithDefaultFlags(cmd)
 	cmd.Flags().StringVar(&o.ConfigFilePath, "config", "/etc/pgboncer-updater/config.yaml", "Config file path")
-	cmd.Flags().StringVar(&o.Query, "query", "reload", "Reload pgbouncer database")
+	cmd.Flags().StringVar(&o.ReloadQuery, "query", o.WithDefaultOptions().ReloadQuery, "Admin console reload query")
 	return cmd
 }
 
 func ReloadCmd(c *cobra.Command, o *options.Options, conf configuration.Configurations) error {
+	_, err := ReloadCopied(c, o, conf, nil, nil, nil)
+	return err
+}
+
+// Reloads is the outcome of each reloaded host, nil when it succeeded.
+type Reloads map[string]error
+
+// ReloadCopied reloads the hosts a copy updated and the unchanged ones
+// pending says still have to be reloaded, failed ones are skipped. Every
+// host is reloaded when results is nil. SSH reloads and tunnels go over the
+// copy clients when given. The error is the first failed reload, the others
+// are in the returned Reloads.
+func ReloadCopied(c *cobra.Command, o *options.Options, conf configuration.Configurations, results copy.Results, pending func(host string) bool, clients copy.Clients) (Reloads, error) {
 	hostVars, err := conf.GetPGBouncerHost()
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	type reloaded struct {
+		host string
+		err  error
 	}
 
 	wg := sync.WaitGroup{}
-	errCh := make(chan error, len(hostVars))
+	resCh := make(chan reloaded, len(hostVars))
 	log.Info("Start PGBouncer reload PGBouncer hosts")
 	for _, hostvar := range hostVars {
 		host := hostvar.DeepCopy()
 
+		if results != nil {
+			result, ok := results[host.Host]
+			switch {
+			case !ok:
+				log.Warn("No copy result for host ", host.Host, ", skip reload")
+				continue
+			case result == copy.Unchanged && pending != nil && pending(host.Host):
+				log.Info("Last reload of host ", host.Host, " failed, reload again")
+			case result == copy.Unchanged:
+				log.Info("No changes copied to host ", host.Host, ", skip reload")
+				continue
+			case result != copy.Updated:
+				log.Warn("Copy to host ", host.Host, " ", result, ", skip reload")
+				continue
+			}
+		}
+
 		wg.Add(1)
-		go func(pgHost *configuration.PGBouncerHost, conf configuration.Configurations) {
+		go func(pgHost *configuration.PGBouncerHost, conf configuration.Configurations, client sendfile.Scp) {
 			defer wg.Done()
 
-			dsn, err := conf.GetPostgresCustomDSN(
-				defaultPGBouncerDB,
-				host.Host,
-				"disable",
-				defaultPostgresPort)
-
-			if err != nil {
-				errCh <- err
+			method := pgHost.Reload.GetMethod()
+			log.Info("Reload PGBouncer on host ", pgHost.Host, " with ", method)
+			if err := reloadHost(c.Context(), o, conf, pgHost, client); err != nil {
+				log.Error("Failed to reload PGBouncer on host ", pgHost.Host, " with ", method, ": ", err)
+				resCh <- reloaded{pgHost.Host, fmt.Errorf("reload %s: %w", pgHost.Host, err)}
 				return
 			}
+			log.Info("PGBouncer reload on host ", pgHost.Host, " done")
+			resCh <- reloaded{pgHost.Host, nil}
+		}(host, conf, clients[host.Host])
+	}
+	wg.Wait()
 
-			// Configure Postgres connection
-			log.Info("Launch PGBouncer reload query on host ", host.Host)
-			db, err := databases.NewQuery(dsn)
-			if err != nil {
-				log.Error("Failed to exec query with dsn ", dsn)
-				errCh <- err
-				return
-			}
-			defer db.Close()
+	close(resCh)
+	reloads := Reloads{}
+	for res := range resCh {
+		reloads[res.host] = res.err
+		if res.err != nil && err == nil {
+			err = res.err
+		}
+	}
 
-			if err := db.ToVoid(o.Query); err != nil {
-				errCh <- err
-				return
-			}
-			log.Info("PGBouncer reload query on host ", host.Host, " done")
-		}(host, conf)
+	return reloads, err
+}
+
+// reloadHost runs the reload query on the admin console of pgHost, or the
+// reload script of its method over SSH. client is the SSH connection of the
+// host when one is already open, a new one is made otherwise.
+func reloadHost(ctx context.Context, o *options.Options, conf configuration.Configurations, pgHost *configuration.PGBouncerHost, client sendfile.Scp) error {
+	script, err := pgHost.Reload.Script()
+	if err != nil {
+		return err
 	}
-	wg.Wait()
 
-	close(errCh)
-	for err := range errCh {
+	if script == "" {
+		db, err := NewAdminConsole(ctx, conf, pgHost, client)
 		if err != nil {
 			return err
 		}
+		defer db.Close()
+
+		query := o.ReloadQuery
+		if query == "" {
+			query = options.DefaultReloadQuery
+		}
+
+		return db.ToVoid(ctx, query)
+	}
+
+	if client == nil {
+		client, err = pgHost.NewClient(o.Sudo)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+	}
+
+	return client.Run(ctx, script)
+}
+
+// NewAdminConsole connects to the PGBouncer admin console of pgHost. A
+// tunnel goes over client when given, through a new SSH connection
+// otherwise.
+func NewAdminConsole(ctx context.Context, conf configuration.Configurations, pgHost *configuration.PGBouncerHost, client sendfile.Scp) (databases.Databases, error) {
+	settings, err := conf.GetDatabaseSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := pgHost.AdminConsole()
+	if err != nil {
+		return nil, err
+	}
+
+	dsn, err := conf.GetPostgresCustomDSN(
+		defaultPGBouncerDB,
+		target.Host,
+		"disable",
+		target.Port)
+
+	if err != nil {
+		return nil, err
+	}
+
+	opts := settings.QueryOptions()
+	switch {
+	case target.Tunnel && client != nil:
+		opts = append(opts, databases.WithDialer(client))
+	case len(target.Hops) > 0:
+		opts = append(opts, databases.WithDialer(sendfile.NewTunnel(target.Hops...)))
 	}
 
-	return nil
+	return databases.NewQuery(ctx, dsn, opts...)
 }
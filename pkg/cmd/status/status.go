@@ -0,0 +1,133 @@
+package status
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/cmd/copy"
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/cmd/options"
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/configuration"
+)
+
+const (
+	getApplicationExample = `
+	# Check the files pushed to every PGBouncer host
+	%[1]s status
+
+	# Check hosts where files are only readable by root
+	%[1]s status --sudo
+	`
+
+	getUsage = `
+	Print the owner, group and mode of the files pushed to each PGBouncer host
+	and whether they drift from the files settings. Exit with an error on drift
+	or missing files.
+	`
+)
+
+var (
+	ErrDrift = errors.New("remote files drift from the config")
+)
+
+func NewCmdStatus(o *options.Options) *cobra.Command {
+
+	var cmd = &cobra.Command{
+		Use:          "pgbouncer-updater status",
+		Short:        "Check the files pushed to hosts",
+		Long:         getUsage,
+		Aliases:      []string{"status", "st"},
+		Example:      o.Exemple(getApplicationExample),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			// Get config from file
+			conf, err := configuration.NewConfigurationFromFile(o.ConfigFilePath)
+			if (err != nil) && err != configuration.FileNotFound {
+				return err
+			}
+
+			if err == configuration.FileNotFound {
+				conf = configuration.NewDefaultConfiguration(o.UserName, o.DBName, o.PGHost, o.Password, o.PGBouncerHosts...)
+			}
+
+			return StatusCmd(c, o, conf)
+		},
+	}
+
+	o.WithDefaultFlags(cmd)
+	cmd.Flags().BoolVar(&o.Sudo, "sudo", o.Sudo, "Read remote files as sudoer")
+	cmd.Flags().StringVar(&o.ConfigFilePath, "config", o.WithDefaultOptions().ConfigFilePath, "Config file path")
+	cmd.Flags().StringVar(&o.DestinationFile, "remote", o.WithDefaultOptions().DestinationFile, "Remote users list file path")
+	return cmd
+}
+
+func StatusCmd(c *cobra.Command, o *options.Options, conf configuration.Configurations) error {
+	hostVars, err := conf.GetPGBouncerHost()
+	if err != nil {
+		return err
+	}
+
+	generated, err := copy.GeneratedFiles(conf)
+	if err != nil {
+		return err
+	}
+
+	remotePaths := []string{}
+	for _, remotePath := range generated {
+		remotePaths = append(remotePaths, remotePath)
+	}
+	sort.Strings(remotePaths)
+	remotePaths = append([]string{o.DestinationFile}, remotePaths...)
+
+	w := tabwriter.NewWriter(c.OutOrStdout(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tFILE\tOWNER\tGROUP\tMODE\tSTATUS")
+
+	drifts := 0
+	for _, pgHost := range hostVars {
+		perms, err := conf.GetFilePermissions(pgHost)
+		if err != nil {
+			return err
+		}
+
+		scp, err := pgHost.NewClient(o.Sudo)
+		if err != nil {
+			return err
+		}
+		for _, remotePath := range remotePaths {
+			file, err := scp.Stat(c.Context(), remotePath)
+			switch {
+			case errors.Is(err, os.ErrNotExist):
+				drifts++
+				fmt.Fprintf(w, "%s\t%s\t-\t-\t-\tmissing\n", pgHost.Host, remotePath)
+				continue
+			case err != nil:
+				log.Error("Failed to stat ", remotePath, " on ", pgHost.Host, ": ", err)
+				scp.Close()
+				return err
+			}
+
+			status := "ok"
+			if drift := perms.Drift(file); len(drift) > 0 {
+				drifts++
+				status = "drift: " + strings.Join(drift, ", ")
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%04o\t%s\n", pgHost.Host, remotePath, file.Owner, file.Group, file.Mode.Perm(), status)
+		}
+		scp.Close()
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if drifts > 0 {
+		return fmt.Errorf("%w: %d files", ErrDrift, drifts)
+	}
+
+	return nil
+}
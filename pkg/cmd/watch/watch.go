@@ -0,0 +1,167 @@
+package watch
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/cmd/aio"
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/cmd/options"
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/configuration"
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/databases"
+)
+
+const (
+	getApplicationExample = `
+	# Sync PGBouncer hosts each time a role change is notified
+	%[1]s watch --config /etc/pgbouncer-updater/config.yaml
+
+	# Install the notify function role management scripts call
+	%[1]s watch --config /etc/pgbouncer-updater/config.yaml --install
+	`
+
+	getUsage = `
+	Listen on the source database notify channel and run the all in one sync
+	when a role change is notified, with a periodic full sync as a safety net.
+
+	Role management scripts signal a change with:
+	  SELECT pgbouncer_updater_notify('reason');
+	`
+)
+
+func NewCmdWatch(o *options.Options) *cobra.Command {
+	var install bool
+
+	var cmd = &cobra.Command{
+		Use:          "pgbouncer-updater watch",
+		Short:        "Sync PGBouncer hosts on role change notifications",
+		Long:         getUsage,
+		Aliases:      []string{"watch", "w"},
+		Example:      o.Exemple(getApplicationExample),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			// Get config from file
+			conf, err := configuration.NewConfigurationFromFile(o.ConfigFilePath)
+			if (err != nil) && err != configuration.FileNotFound {
+				return err
+			}
+
+			if err == configuration.FileNotFound {
+				conf = configuration.NewDefaultConfiguration(o.UserName, o.DBName, o.PGHost, o.Password, o.PGBouncerHosts...)
+			}
+
+			if install {
+				return InstallCmd(c, o, conf)
+			}
+
+			return WatchCmd(c, o, conf)
+		},
+	}
+
+	o.WithDefaultFlags(cmd)
+	cmd.Flags().BoolVar(&o.Sudo, "sudo", o.Sudo, "Copy file as sudoer")
+	cmd.Flags().StringVar(&o.ConfigFilePath, "config", o.WithDefaultOptions().ConfigFilePath, "Config file path")
+	cmd.Flags().StringVar(&o.StateFile, "state", o.WithDefaultOptions().StateFile, "Last successful push state file")
+	cmd.Flags().BoolVar(&o.Header, "header", o.Header, "Write the list source in a header comment")
+	cmd.Flags().BoolVar(&o.Verify, "verify", o.Verify, "Read back copied files before they replace the remote ones, hosts where they differ are not reloaded")
+	cmd.Flags().BoolVar(&install, "install", false, "Install the notify function in the source database and exit")
+	return cmd
+}
+
+// InstallCmd creates the notify function in the source database.
+func InstallCmd(c *cobra.Command, o *options.Options, conf configuration.Configurations) error {
+	dsn, err := conf.GetPostgresDSN()
+	if err != nil {
+		return err
+	}
+
+	settings, err := conf.GetDatabaseSettings()
+	if err != nil {
+		return err
+	}
+
+	watch, err := conf.GetWatchSettings()
+	if err != nil {
+		return err
+	}
+
+	db, err := databases.NewQuery(c.Context(), dsn, settings.QueryOptions()...)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := db.ToVoid(c.Context(), databases.NotifyFunctionSQL(watch.Channel)); err != nil {
+		return err
+	}
+
+	log.Info("Function ", databases.NotifyFunction, " notifying channel ", watch.Channel, " installed")
+	return nil
+}
+
+// WatchCmd runs a sync at start, after each burst of notifications once the
+// debounce delay elapsed and on every full sync interval until the command
+// context is cancelled.
+func WatchCmd(c *cobra.Command, o *options.Options, conf configuration.Configurations) error {
+	dsn, err := conf.GetPostgresDSN()
+	if err != nil {
+		return err
+	}
+
+	settings, err := conf.GetWatchSettings()
+	if err != nil {
+		return err
+	}
+
+	listener, err := databases.NewListener(c.Context(), dsn, settings.Channel)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	log.Info("Listen for role changes on channel ", settings.Channel)
+	sync(c, o, conf, false)
+
+	fullSync := time.NewTicker(settings.FullSyncInterval)
+	defer fullSync.Stop()
+
+	var debounce <-chan time.Time
+	for {
+		select {
+		case <-c.Context().Done():
+			log.Info("Stop watching role changes")
+			return nil
+
+		case n, ok := <-listener.Notifications():
+			if !ok {
+				return c.Context().Err()
+			}
+
+			if n.Reconnected {
+				log.Warn("Listener reconnected, notifications may have been lost")
+			} else {
+				log.Info("Role change notified ", n.Payload)
+			}
+			debounce = time.After(settings.Debounce)
+
+		case <-debounce:
+			debounce = nil
+			sync(c, o, conf, false)
+
+		case <-fullSync.C:
+			log.Info("Start periodic full sync")
+			sync(c, o, conf, true)
+		}
+	}
+}
+
+// sync runs the all in one command, errors are logged as the next
+// notification or full sync retries it.
+func sync(c *cobra.Command, o *options.Options, conf configuration.Configurations, force bool) {
+	opts := *o
+	opts.Resync = force
+
+	if err := aio.AIOCmd(c, &opts, conf); err != nil {
+		log.Error("Sync failed: ", err)
+	}
+}
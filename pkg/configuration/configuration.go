@@ -3,14 +3,28 @@ package configuration
 import (
 	"io"
 	"os"
+
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/audit"
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/guard"
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/sendfile"
 )
 
 type Configurations interface {
 	WriteToFile() (int, error)
 	WithPrivKeyFromFile(filePath string) (*Configuration, error)
 	GetPostgresDSN() (string, error)
+	GetPostgresSource() (string, error)
 	GetPostgresCustomDSN(dbname, host, sslmode string, port int64) (string, error)
 	GetPGBouncerHost() ([]*PGBouncerHost, error)
+	GetDatabaseSettings() (*DatabaseSettings, error)
+	GetWatchSettings() (*WatchSettings, error)
+	GetAuditPolicy() (*audit.Policy, error)
+	GetExtraUsers() (*ExtraUsers, error)
+	GetGuardPolicy() (*guard.Policy, error)
+	GetPGBouncerDBs() (*PGBouncerDBs, error)
+	GetPGBouncerUsers() (*PGBouncerUsers, error)
+	GetHBASettings() (*HBASettings, error)
+	GetFilePermissions(pgHost *PGBouncerHost) (*sendfile.Permissions, error)
 }
 
 func NewConfiguration(file io.Reader) Configurations {
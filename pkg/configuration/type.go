@@ -5,26 +5,66 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	_ "github.com/lib/pq"
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/audit"
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/databases"
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/guard"
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/hba"
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/pgbouncerini"
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/sendfile"
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/userlist"
 	"gopkg.in/yaml.v3"
 )
 
 const (
 	dsn                = "dbname=%s host=%s port=%d user=%s password=%s sslmode=%s"
+	source             = "%s@%s:%d/%s"
 	DefaultPGPort      = 5432
 	DefaultSSHPort     = 22
 	DefaultSSHUsername = "ansible"
 	DefaultSSMode      = "disable"
 	DefaultFileName    = "config.yaml"
 	DefaultPrivKeyPath = "%s/.ssh/id_rsa_ansible"
+
+	DefaultWatchDebounce    = 5 * time.Second
+	DefaultFullSyncInterval = time.Hour
+
+	DefaultDatabasesFile   = "databases.ini"
+	DefaultDatabasesRemote = "/etc/pgbouncer/databases.ini"
+	DefaultUsersFile       = "users.ini"
+	DefaultUsersRemote     = "/etc/pgbouncer/users.ini"
+	DefaultHBAFile         = "pgbouncer_hba.conf"
+	DefaultHBARemote       = "/etc/pgbouncer/pgbouncer_hba.conf"
+
+	DefaultTunnelConsoleHost = "127.0.0.1"
+	DefaultReloadUnit        = "pgbouncer"
+	DefaultReloadPidFile     = "/var/run/pgbouncer/pgbouncer.pid"
+
+	ReloadAdminConsole = "admin-console"
+	ReloadSystemctl    = "systemctl"
+	ReloadKill         = "kill"
+	ReloadDocker       = "docker"
+	ReloadCommand      = "command"
 )
 
 type Configuration struct {
 	configStream   io.Reader
-	Postgrescred   *PostGresCred    `yaml:"credentials"`
-	PGbouncerHosts []*PGBouncerHost `yaml:"hosts"`
+	Postgrescred   *PostGresCred     `yaml:"credentials"`
+	PGbouncerHosts []*PGBouncerHost  `yaml:"hosts"`
+	Database       *DatabaseSettings `yaml:"database,omitempty"`
+	Watch          *WatchSettings    `yaml:"watch,omitempty"`
+	Audit          *audit.Policy     `yaml:"audit,omitempty"`
+	ExtraUsers     *ExtraUsers       `yaml:"extra_users,omitempty"`
+	Guards         *guard.Policy     `yaml:"guards,omitempty"`
+	PGBouncerDBs   *PGBouncerDBs     `yaml:"pgbouncer_databases,omitempty"`
+	PGBouncerUsers *PGBouncerUsers   `yaml:"pgbouncer_users,omitempty"`
+	HBA            *HBASettings      `yaml:"hba,omitempty"`
+	Files          *FilePermissions  `yaml:"files,omitempty"`
+	JumpHosts      []*JumpHost       `yaml:"jump_hosts,omitempty"`
 }
 
 type PostGresCred struct {
@@ -36,18 +76,279 @@ type PostGresCred struct {
 	UserName string `yaml:"username"`
 }
 
+// DatabaseSettings bounds every query sent to the source database and to
+// the PGBouncer admin consoles.
+type DatabaseSettings struct {
+	ConnectTimeout   time.Duration `yaml:"connect_timeout,omitempty"`
+	StatementTimeout time.Duration `yaml:"statement_timeout,omitempty"`
+	Retries          *int          `yaml:"retries,omitempty"`
+	RetryBackoff     time.Duration `yaml:"retry_backoff,omitempty"`
+}
+
+// WatchSettings configures the LISTEN/NOTIFY triggered sync.
+type WatchSettings struct {
+	Channel          string        `yaml:"channel,omitempty"`
+	Debounce         time.Duration `yaml:"debounce,omitempty"`
+	FullSyncInterval time.Duration `yaml:"full_sync_interval,omitempty"`
+}
+
+// ExtraUsers are PGBouncer only accounts merged into the generated user
+// list, from an auth file and from the users list, the latter winning.
+type ExtraUsers struct {
+	// extra or database, which one is kept when both define a user
+	Precedence userlist.Precedence `yaml:"precedence,omitempty"`
+	File       string              `yaml:"file,omitempty"`
+	Users      []*ExtraUser        `yaml:"users,omitempty"`
+}
+
+// PGBouncerDBs generates the [databases] fragment pgbouncer.ini includes,
+// PGBouncer connects to the credentials server unless host is set.
+type PGBouncerDBs struct {
+	// Local fragment path
+	File string `yaml:"file,omitempty"`
+	// Remote fragment path, as written in the pgbouncer.ini %include
+	Remote                     string `yaml:"remote,omitempty"`
+	pgbouncerini.DatabaseRules `yaml:",inline"`
+}
+
+// PGBouncerUsers generates the [users] fragment pgbouncer.ini includes, from
+// role comments then from the users mapping, the latter winning.
+type PGBouncerUsers struct {
+	File   string `yaml:"file,omitempty"`
+	Remote string `yaml:"remote,omitempty"`
+	// Read settings from 'pgbouncer: pool_mode=transaction' role comments
+	FromComments bool                                  `yaml:"from_comments,omitempty"`
+	Users        map[string]*pgbouncerini.UserSettings `yaml:"users,omitempty"`
+}
+
+// HBASettings generates the auth_hba_file used with auth_type = hba.
+type HBASettings struct {
+	File   string      `yaml:"file,omitempty"`
+	Remote string      `yaml:"remote,omitempty"`
+	Rules  []*hba.Rule `yaml:"rules"`
+}
+
+type ExtraUser struct {
+	UserName string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
 type PGBouncerHost struct {
 	Host     string `yaml:"host"`
 	Port     int64  `yaml:"port"`
 	UserName string `yaml:"username"`
 	PrivKey  string `yaml:"privkey"`
+	// Only replace the lines between the pgbouncer-updater markers of the
+	// remote userlist, keeping hand written entries around them
+	ManagedBlock bool `yaml:"managed_block,omitempty"`
+	// Overrides the global files settings
+	Files *FilePermissions `yaml:"files,omitempty"`
+	// scp or sftp, scp when unset
+	Transport string `yaml:"transport,omitempty"`
+	// Overrides the global jump hosts chain
+	JumpHosts []*JumpHost     `yaml:"jump_hosts,omitempty"`
+	Reload    *ReloadSettings `yaml:"reload,omitempty"`
+}
+
+// ReloadSettings tells how a PGBouncer host is reloaded, through its admin
+// console by default or by a command run over SSH, systemctl, kill and docker
+// through sudo.
+type ReloadSettings struct {
+	// admin-console, systemctl, kill, docker or command
+	Method string `yaml:"method,omitempty"`
+	// systemd unit of systemctl
+	Unit string `yaml:"unit,omitempty"`
+	// PGBouncer pidfile kill sends SIGHUP to
+	PidFile string `yaml:"pidfile,omitempty"`
+	// Container docker sends SIGHUP to
+	Container string `yaml:"container,omitempty"`
+	// Shell command of command, run as the SSH user, it uses sudo itself
+	// when it needs to
+	Command string `yaml:"command,omitempty"`
+	// Forward the admin console connection over SSH to the host, through
+	// its jump hosts if any
+	Tunnel bool `yaml:"tunnel,omitempty"`
+	// Admin console address as seen from the host when tunnelled, a
+	// directory holding the unix socket when it starts with a slash
+	Host string `yaml:"host,omitempty"`
+	Port int64  `yaml:"port,omitempty"`
+}
+
+// GetMethod returns the reload method, admin-console when unset.
+func (r *ReloadSettings) GetMethod() string {
+	if r == nil || r.Method == "" {
+		return ReloadAdminConsole
+	}
+
+	return r.Method
+}
+
+// Script returns the shell script reloading PGBouncer on the host, empty
+// for admin-console.
+func (r *ReloadSettings) Script() (string, error) {
+	switch r.GetMethod() {
+	case ReloadAdminConsole:
+		return "", nil
+
+	case ReloadSystemctl:
+		unit := r.Unit
+		if unit == "" {
+			unit = DefaultReloadUnit
+		}
+		return "sudo systemctl reload " + sendfile.ShellQuote(unit), nil
+
+	case ReloadKill:
+		pidFile := r.PidFile
+		if pidFile == "" {
+			pidFile = DefaultReloadPidFile
+		}
+		return fmt.Sprintf("sudo kill -HUP \"$(cat -- %s)\"", sendfile.ShellQuote(pidFile)), nil
+
+	case ReloadDocker:
+		if r.Container == "" {
+			return "", fmt.Errorf("reload: method %s needs a container", ReloadDocker)
+		}
+		return "sudo docker kill -s HUP " + sendfile.ShellQuote(r.Container), nil
+
+	case ReloadCommand:
+		if r.Command == "" {
+			return "", fmt.Errorf("reload: method %s needs a command", ReloadCommand)
+		}
+		return r.Command, nil
+	}
+
+	return "", fmt.Errorf("reload: unknown method %q, use %s, %s, %s, %s or %s", r.Method,
+		ReloadAdminConsole, ReloadSystemctl, ReloadKill, ReloadDocker, ReloadCommand)
+}
+
+// AdminConsoleTarget is where the admin console of a host is dialed, from
+// the last hop when there are hops.
+type AdminConsoleTarget struct {
+	Host string
+	Port int64
+	Hops []*sendfile.Hop
+	// Dialed from the host itself, the last hop
+	Tunnel bool
+}
+
+// JumpHost is an SSH bastion PGBouncer hosts are reached through, in chain
+// order. The port, username and key of the PGBouncer host are used when
+// unset.
+type JumpHost struct {
+	Host     string `yaml:"host"`
+	Port     int64  `yaml:"port,omitempty"`
+	UserName string `yaml:"username,omitempty"`
+	PrivKey  string `yaml:"privkey,omitempty"`
 }
 
+// FilePermissions of the files pushed to PGBouncer hosts, the mode is
+// octal, unset fields keep the ones of the replaced file. New files are
+// 0640, owned by the SSH user or root with sudo.
+type FilePermissions struct {
+	Owner string `yaml:"owner,omitempty"`
+	Group string `yaml:"group,omitempty"`
+	Mode  string `yaml:"mode,omitempty"`
+}
+
+// NewClient returns a file transfer client to the host.
+func (h *PGBouncerHost) NewClient(sudo bool) (sendfile.Scp, error) {
+	host := fmt.Sprintf("%s:%d", h.Host, h.Port)
+	return sendfile.NewClient(h.Transport, host, h.UserName, h.Port, []byte(h.PrivKey), sudo, h.JumpHops()...)
+}
+
+// Hop returns the host as the last hop of an SSH tunnel.
+func (h *PGBouncerHost) Hop() *sendfile.Hop {
+	return &sendfile.Hop{
+		Addr:     fmt.Sprintf("%s:%d", h.Host, h.Port),
+		Username: h.UserName,
+		PrivKey:  []byte(h.PrivKey),
+	}
+}
+
+// AdminConsole resolves where the admin console of the host is reached,
+// the host itself on the default port unless reload settings tell
+// otherwise.
+func (h *PGBouncerHost) AdminConsole() (*AdminConsoleTarget, error) {
+	target := &AdminConsoleTarget{
+		Host: h.Host,
+		Port: DefaultPGPort,
+		Hops: h.JumpHops(),
+	}
+
+	reload := h.Reload
+	if reload == nil {
+		reload = &ReloadSettings{}
+	}
+
+	if reload.Tunnel {
+		target.Host = DefaultTunnelConsoleHost
+		target.Hops = append(target.Hops, h.Hop())
+		target.Tunnel = true
+	}
+
+	if reload.Host != "" {
+		if strings.HasPrefix(reload.Host, "/") && !reload.Tunnel {
+			return nil, fmt.Errorf("reload: unix socket %s of host %s is only reachable with tunnel", reload.Host, h.Host)
+		}
+		target.Host = reload.Host
+	}
+
+	if reload.Port != 0 {
+		target.Port = reload.Port
+	}
+
+	return target, nil
+}
+
+// JumpHops returns the jump hosts chain of the host, with its defaults.
+func (h *PGBouncerHost) JumpHops() []*sendfile.Hop {
+	hops := []*sendfile.Hop{}
+	for _, jump := range h.JumpHosts {
+		hop := &sendfile.Hop{
+			Addr:     fmt.Sprintf("%s:%d", jump.Host, jump.Port),
+			Username: jump.UserName,
+			PrivKey:  []byte(jump.PrivKey),
+		}
+
+		if jump.Port == 0 {
+			hop.Addr = fmt.Sprintf("%s:%d", jump.Host, DefaultSSHPort)
+		}
+
+		if hop.Username == "" {
+			hop.Username = h.UserName
+		}
+
+		if len(hop.PrivKey) == 0 {
+			hop.PrivKey = []byte(h.PrivKey)
+		}
+
+		hops = append(hops, hop)
+	}
+
+	return hops
+}
+
+// GetPGBouncerHost returns the PGBouncer hosts, the ones without their own
+// jump hosts get the global chain.
 func (conf *Configuration) GetPGBouncerHost() ([]*PGBouncerHost, error) {
 	if err := conf.parseConfigFile(); err != nil {
 		return nil, err
 	}
-	return conf.PGbouncerHosts, nil
+
+	hosts := []*PGBouncerHost{}
+	for _, pgHost := range conf.PGbouncerHosts {
+		if pgHost == nil {
+			continue
+		}
+
+		host := pgHost.DeepCopy()
+		if len(host.JumpHosts) == 0 {
+			host.JumpHosts = copyJumpHosts(conf.JumpHosts)
+		}
+		hosts = append(hosts, host)
+	}
+
+	return hosts, nil
 }
 
 func (conf *Configuration) GetPostgresDSN() (string, error) {
@@ -59,6 +360,16 @@ func (conf *Configuration) GetPostgresDSN() (string, error) {
 	return fmt.Sprintf(dsn, cred.DBName, cred.Host, cred.Port, cred.UserName, cred.Password, cred.SSLmode), nil
 }
 
+// GetPostgresSource describes the source database without its password.
+func (conf *Configuration) GetPostgresSource() (string, error) {
+	if err := conf.parseConfigFile(); err != nil {
+		return "", err
+	}
+	cred := conf.Postgrescred
+
+	return fmt.Sprintf(source, cred.UserName, cred.Host, cred.Port, cred.DBName), nil
+}
+
 func (conf *Configuration) GetPostgresCustomDSN(dbname, host, sslmode string, port int64) (string, error) {
 	if err := conf.parseConfigFile(); err != nil {
 		return "", err
@@ -68,6 +379,271 @@ func (conf *Configuration) GetPostgresCustomDSN(dbname, host, sslmode string, po
 	return fmt.Sprintf(dsn, dbname, host, port, cred.UserName, cred.Password, sslmode), nil
 }
 
+// GetDatabaseSettings returns the database section, unset values are
+// replaced by the databases package defaults.
+func (conf *Configuration) GetDatabaseSettings() (*DatabaseSettings, error) {
+	if err := conf.parseConfigFile(); err != nil {
+		return nil, err
+	}
+
+	settings := conf.Database.DeepCopy()
+	if settings == nil {
+		settings = new(DatabaseSettings)
+	}
+
+	if settings.ConnectTimeout <= 0 {
+		settings.ConnectTimeout = databases.DefaultConnectTimeout
+	}
+
+	if settings.StatementTimeout <= 0 {
+		settings.StatementTimeout = databases.DefaultStatementTimeout
+	}
+
+	if settings.Retries == nil {
+		retries := databases.DefaultRetries
+		settings.Retries = &retries
+	}
+
+	if settings.RetryBackoff <= 0 {
+		settings.RetryBackoff = databases.DefaultRetryBackoff
+	}
+
+	return settings, nil
+}
+
+// GetWatchSettings returns the watch section with defaults for unset values.
+func (conf *Configuration) GetWatchSettings() (*WatchSettings, error) {
+	if err := conf.parseConfigFile(); err != nil {
+		return nil, err
+	}
+
+	settings := conf.Watch.DeepCopy()
+	if settings == nil {
+		settings = new(WatchSettings)
+	}
+
+	if settings.Channel == "" {
+		settings.Channel = databases.DefaultNotifyChannel
+	}
+
+	if settings.Debounce <= 0 {
+		settings.Debounce = DefaultWatchDebounce
+	}
+
+	if settings.FullSyncInterval <= 0 {
+		settings.FullSyncInterval = DefaultFullSyncInterval
+	}
+
+	return settings, nil
+}
+
+// GetAuditPolicy returns the audit section, an empty policy when unset.
+func (conf *Configuration) GetAuditPolicy() (*audit.Policy, error) {
+	if err := conf.parseConfigFile(); err != nil {
+		return nil, err
+	}
+
+	if conf.Audit == nil {
+		return new(audit.Policy), nil
+	}
+
+	policy := *conf.Audit
+	return &policy, nil
+}
+
+// GetGuardPolicy returns the guards section, an empty policy when unset.
+func (conf *Configuration) GetGuardPolicy() (*guard.Policy, error) {
+	if err := conf.parseConfigFile(); err != nil {
+		return nil, err
+	}
+
+	if conf.Guards == nil {
+		return new(guard.Policy), nil
+	}
+
+	policy := *conf.Guards
+	return &policy, nil
+}
+
+// GetPGBouncerDBs returns the pgbouncer_databases section with its
+// defaults, nil when unset.
+func (conf *Configuration) GetPGBouncerDBs() (*PGBouncerDBs, error) {
+	if err := conf.parseConfigFile(); err != nil {
+		return nil, err
+	}
+
+	if conf.PGBouncerDBs == nil {
+		return nil, nil
+	}
+
+	dbs := conf.PGBouncerDBs.DeepCopy()
+	if dbs.File == "" {
+		dbs.File = DefaultDatabasesFile
+	}
+
+	if dbs.Remote == "" {
+		dbs.Remote = DefaultDatabasesRemote
+	}
+
+	if dbs.Host == "" && conf.Postgrescred != nil {
+		dbs.Host = conf.Postgrescred.Host
+		dbs.Port = conf.Postgrescred.Port
+	}
+
+	if err := dbs.Validate(); err != nil {
+		return nil, fmt.Errorf("pgbouncer_databases: %w", err)
+	}
+
+	return dbs, nil
+}
+
+// GetPGBouncerUsers returns the pgbouncer_users section with its defaults,
+// nil when unset.
+func (conf *Configuration) GetPGBouncerUsers() (*PGBouncerUsers, error) {
+	if err := conf.parseConfigFile(); err != nil {
+		return nil, err
+	}
+
+	if conf.PGBouncerUsers == nil {
+		return nil, nil
+	}
+
+	users := conf.PGBouncerUsers.DeepCopy()
+	if users.File == "" {
+		users.File = DefaultUsersFile
+	}
+
+	if users.Remote == "" {
+		users.Remote = DefaultUsersRemote
+	}
+
+	for name, settings := range users.Users {
+		if settings == nil {
+			delete(users.Users, name)
+			continue
+		}
+
+		if err := settings.Validate(); err != nil {
+			return nil, fmt.Errorf("pgbouncer_users: user %s: %w", name, err)
+		}
+	}
+
+	return users, nil
+}
+
+// GetHBASettings returns the validated hba section with its defaults, nil
+// when unset.
+func (conf *Configuration) GetHBASettings() (*HBASettings, error) {
+	if err := conf.parseConfigFile(); err != nil {
+		return nil, err
+	}
+
+	if conf.HBA == nil {
+		return nil, nil
+	}
+
+	settings := conf.HBA.DeepCopy()
+	if settings.File == "" {
+		settings.File = DefaultHBAFile
+	}
+
+	if settings.Remote == "" {
+		settings.Remote = DefaultHBARemote
+	}
+
+	if err := hba.Validate(settings.Rules); err != nil {
+		return nil, err
+	}
+
+	return settings, nil
+}
+
+// GetFilePermissions returns the permissions of the files pushed to pgHost,
+// its own settings winning over the global ones field by field.
+func (conf *Configuration) GetFilePermissions(pgHost *PGBouncerHost) (*sendfile.Permissions, error) {
+	if err := conf.parseConfigFile(); err != nil {
+		return nil, err
+	}
+
+	merged := FilePermissions{}
+	for _, files := range []*FilePermissions{conf.Files, pgHost.Files} {
+		if files == nil {
+			continue
+		}
+
+		if files.Owner != "" {
+			merged.Owner = files.Owner
+		}
+
+		if files.Group != "" {
+			merged.Group = files.Group
+		}
+
+		if files.Mode != "" {
+			merged.Mode = files.Mode
+		}
+	}
+
+	perms := &sendfile.Permissions{
+		Owner: merged.Owner,
+		Group: merged.Group,
+	}
+
+	if merged.Mode != "" {
+		mode, err := strconv.ParseUint(merged.Mode, 8, 32)
+		if err != nil || mode > 0777 {
+			return nil, fmt.Errorf("files: mode %q of host %s is not an octal permission", merged.Mode, pgHost.Host)
+		}
+		perms.Mode = os.FileMode(mode)
+	}
+
+	return perms, nil
+}
+
+// GetExtraUsers returns the extra_users section, nil when unset.
+func (conf *Configuration) GetExtraUsers() (*ExtraUsers, error) {
+	if err := conf.parseConfigFile(); err != nil {
+		return nil, err
+	}
+
+	return conf.ExtraUsers.DeepCopy(), nil
+}
+
+// Load reads the extra users file then appends the inline users.
+func (e *ExtraUsers) Load() ([]*userlist.User, error) {
+	users := []*userlist.User{}
+	if e.File != "" {
+		fromFile, err := userlist.ParseFile(e.File)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, fromFile...)
+	}
+
+	for _, user := range e.Users {
+		users = append(users, &userlist.User{
+			UserName: user.UserName,
+			Md5:      user.Password,
+		})
+	}
+
+	return users, nil
+}
+
+// QueryOptions converts the settings to databases.NewQuery options.
+func (s *DatabaseSettings) QueryOptions() []databases.Option {
+	opts := []databases.Option{
+		databases.WithConnectTimeout(s.ConnectTimeout),
+		databases.WithStatementTimeout(s.StatementTimeout),
+	}
+
+	if s.Retries != nil {
+		opts = append(opts, databases.WithRetries(*s.Retries, s.RetryBackoff))
+	}
+
+	return opts
+}
+
 func (conf *Configuration) GenerateDefaultConfig() *Configuration {
 	defaultConf := &Configuration{
 		Postgrescred: &PostGresCred{
@@ -131,6 +707,9 @@ func (conf *Configuration) WithPrivKeyFromFile(filePath string) (*Configuration,
 	}
 
 	for _, host := range conf.PGbouncerHosts {
+		if host == nil {
+			continue
+		}
 		host.PrivKey = buf.String()
 	}
 
@@ -165,6 +744,34 @@ func (in *Configuration) DeepCopy() *Configuration {
 
 func (in *PGBouncerHost) deepCopyInto(out *PGBouncerHost) {
 	*out = *in
+	if in.Files != nil {
+		files := *in.Files
+		out.Files = &files
+	}
+	out.JumpHosts = copyJumpHosts(in.JumpHosts)
+	if in.Reload != nil {
+		reload := *in.Reload
+		out.Reload = &reload
+	}
+}
+
+// copyJumpHosts copies the chain, dropping the nil entries a bare "-" or
+// "null" leaves in a YAML list.
+func copyJumpHosts(in []*JumpHost) []*JumpHost {
+	if in == nil {
+		return nil
+	}
+
+	out := make([]*JumpHost, 0, len(in))
+	for _, jump := range in {
+		if jump == nil {
+			continue
+		}
+		copied := *jump
+		out = append(out, &copied)
+	}
+
+	return out
 }
 
 func (in *PGBouncerHost) DeepCopy() *PGBouncerHost {
@@ -190,3 +797,137 @@ func (in *PostGresCred) DeepCopy() *PostGresCred {
 	in.deepCopyInto(out)
 	return out
 }
+
+func (in *DatabaseSettings) deepCopyInto(out *DatabaseSettings) {
+	*out = *in
+	if in.Retries != nil {
+		retries := *in.Retries
+		out.Retries = &retries
+	}
+}
+
+func (in *DatabaseSettings) DeepCopy() *DatabaseSettings {
+	if in == nil {
+		return nil
+	}
+
+	out := new(DatabaseSettings)
+	in.deepCopyInto(out)
+	return out
+}
+
+func (in *WatchSettings) deepCopyInto(out *WatchSettings) {
+	*out = *in
+}
+
+func (in *WatchSettings) DeepCopy() *WatchSettings {
+	if in == nil {
+		return nil
+	}
+
+	out := new(WatchSettings)
+	in.deepCopyInto(out)
+	return out
+}
+
+func (in *ExtraUsers) deepCopyInto(out *ExtraUsers) {
+	*out = *in
+	if in.Users != nil {
+		out.Users = make([]*ExtraUser, 0, len(in.Users))
+		for _, user := range in.Users {
+			if user == nil {
+				continue
+			}
+			copied := *user
+			out.Users = append(out.Users, &copied)
+		}
+	}
+}
+
+func (in *ExtraUsers) DeepCopy() *ExtraUsers {
+	if in == nil {
+		return nil
+	}
+
+	out := new(ExtraUsers)
+	in.deepCopyInto(out)
+	return out
+}
+
+func (in *PGBouncerDBs) deepCopyInto(out *PGBouncerDBs) {
+	*out = *in
+	out.Include = append([]string(nil), in.Include...)
+	out.Exclude = append([]string(nil), in.Exclude...)
+	if in.Overrides != nil {
+		out.Overrides = make(map[string]*pgbouncerini.DatabaseOverride, len(in.Overrides))
+		for name, override := range in.Overrides {
+			if override == nil {
+				continue
+			}
+			copied := *override
+			out.Overrides[name] = &copied
+		}
+	}
+}
+
+func (in *PGBouncerDBs) DeepCopy() *PGBouncerDBs {
+	if in == nil {
+		return nil
+	}
+
+	out := new(PGBouncerDBs)
+	in.deepCopyInto(out)
+	return out
+}
+
+func (in *PGBouncerUsers) deepCopyInto(out *PGBouncerUsers) {
+	*out = *in
+	if in.Users != nil {
+		out.Users = make(map[string]*pgbouncerini.UserSettings, len(in.Users))
+		for name, settings := range in.Users {
+			if settings == nil {
+				out.Users[name] = nil
+				continue
+			}
+			copied := *settings
+			out.Users[name] = &copied
+		}
+	}
+}
+
+func (in *PGBouncerUsers) DeepCopy() *PGBouncerUsers {
+	if in == nil {
+		return nil
+	}
+
+	out := new(PGBouncerUsers)
+	in.deepCopyInto(out)
+	return out
+}
+
+func (in *HBASettings) deepCopyInto(out *HBASettings) {
+	*out = *in
+	if in.Rules != nil {
+		out.Rules = make([]*hba.Rule, 0, len(in.Rules))
+		for _, rule := range in.Rules {
+			if rule == nil {
+				continue
+			}
+			copied := *rule
+			copied.Database = append([]string(nil), rule.Database...)
+			copied.User = append([]string(nil), rule.User...)
+			copied.Role = append([]string(nil), rule.Role...)
+			out.Rules = append(out.Rules, &copied)
+		}
+	}
+}
+
+func (in *HBASettings) DeepCopy() *HBASettings {
+	if in == nil {
+		return nil
+	}
+
+	out := new(HBASettings)
+	in.deepCopyInto(out)
+	return out
+}
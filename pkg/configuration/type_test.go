@@ -3,10 +3,17 @@ package configuration
 import (
 	"bytes"
 	"io"
+	"os"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	_ "github.com/lib/pq"
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/hba"
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/pgbouncerini"
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/sendfile"
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/userlist"
 	"gopkg.in/yaml.v3"
 )
 
@@ -391,3 +398,551 @@ QVBd+KAa2vXMHyzDAAAAEGpvYmRAUkQtTElOVVgtMDEBAg==
 		})
 	}
 }
+
+func TestConfiguration_GetDatabaseSettings(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		want    *DatabaseSettings
+		wantErr bool
+	}{
+		{
+			name:   "Defaults",
+			config: "credentials:\n    host: localhost\n",
+			want: &DatabaseSettings{
+				ConnectTimeout:   10 * time.Second,
+				StatementTimeout: 30 * time.Second,
+				Retries:          func(i int) *int { return &i }(3),
+				RetryBackoff:     500 * time.Millisecond,
+			},
+		},
+		{
+			name:   "Custom",
+			config: "database:\n    connect_timeout: 2s\n    statement_timeout: 1m\n    retries: 0\n",
+			want: &DatabaseSettings{
+				ConnectTimeout:   2 * time.Second,
+				StatementTimeout: time.Minute,
+				Retries:          func(i int) *int { return &i }(0),
+				RetryBackoff:     500 * time.Millisecond,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &Configuration{
+				configStream: strings.NewReader(tt.config),
+			}
+			got, err := conf.GetDatabaseSettings()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Configuration.GetDatabaseSettings() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Configuration.GetDatabaseSettings() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfiguration_GetWatchSettings(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   *WatchSettings
+	}{
+		{
+			name:   "Defaults",
+			config: "credentials:\n    host: localhost\n",
+			want: &WatchSettings{
+				Channel:          "pgbouncer_updater",
+				Debounce:         5 * time.Second,
+				FullSyncInterval: time.Hour,
+			},
+		},
+		{
+			name:   "Custom",
+			config: "watch:\n    channel: roles\n    debounce: 1s\n    full_sync_interval: 10m\n",
+			want: &WatchSettings{
+				Channel:          "roles",
+				Debounce:         time.Second,
+				FullSyncInterval: 10 * time.Minute,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &Configuration{
+				configStream: strings.NewReader(tt.config),
+			}
+			got, err := conf.GetWatchSettings()
+			if err != nil {
+				t.Errorf("Configuration.GetWatchSettings() error = %v", err)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Configuration.GetWatchSettings() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfiguration_GetPostgresSource(t *testing.T) {
+	stream, err := createStream()
+	if err != nil {
+		t.Errorf("Error while generate a test stream %s", err)
+		return
+	}
+
+	conf := &Configuration{
+		configStream: stream,
+	}
+	got, err := conf.GetPostgresSource()
+	if err != nil {
+		t.Errorf("Configuration.GetPostgresSource() error = %v", err)
+		return
+	}
+	if want := "postgres@10.29.0.0:5432/databaseName"; got != want {
+		t.Errorf("Configuration.GetPostgresSource() = %v, want %v", got, want)
+	}
+}
+
+func TestExtraUsers_Load(t *testing.T) {
+	file := t.TempDir() + "/extra.txt"
+	if err := os.WriteFile(file, []byte("; monitoring\n\"stats\" \"md5aaa\"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &Configuration{
+		configStream: strings.NewReader("extra_users:\n    precedence: database\n    file: " + file + "\n    users:\n        - username: pgbouncer\n          password: SCRAM-SHA-256$x\n"),
+	}
+	extraUsers, err := conf.GetExtraUsers()
+	if err != nil {
+		t.Errorf("Configuration.GetExtraUsers() error = %v", err)
+		return
+	}
+	if extraUsers.Precedence != userlist.PrecedenceDatabase {
+		t.Errorf("ExtraUsers.Precedence = %v, want %v", extraUsers.Precedence, userlist.PrecedenceDatabase)
+	}
+
+	got, err := extraUsers.Load()
+	if err != nil {
+		t.Errorf("ExtraUsers.Load() error = %v", err)
+		return
+	}
+	want := []*userlist.User{
+		{UserName: "stats", Md5: "md5aaa"},
+		{UserName: "pgbouncer", Md5: "SCRAM-SHA-256$x"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ExtraUsers.Load() = %v, want %v", got, want)
+	}
+}
+
+func TestConfiguration_GetPGBouncerDBs(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		want    *PGBouncerDBs
+		wantErr bool
+	}{
+		{
+			name:   "Unset",
+			config: "credentials:\n    host: localhost\n",
+		},
+		{
+			name:   "Defaults",
+			config: "credentials:\n    host: 10.0.0.1\n    port: 5432\npgbouncer_databases:\n    exclude: [postgres]\n",
+			want: &PGBouncerDBs{
+				File:   DefaultDatabasesFile,
+				Remote: DefaultDatabasesRemote,
+				DatabaseRules: pgbouncerini.DatabaseRules{
+					Host:    "10.0.0.1",
+					Port:    5432,
+					Exclude: []string{"postgres"},
+				},
+			},
+		},
+		{
+			name:    "Invalid pool mode",
+			config:  "pgbouncer_databases:\n    overrides:\n        app:\n            pool_mode: txn\n",
+			wantErr: true,
+		},
+		{
+			name:    "Credentials host with port",
+			config:  "credentials:\n    host: db:5432\npgbouncer_databases:\n    exclude: [postgres]\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &Configuration{
+				configStream: strings.NewReader(tt.config),
+			}
+			got, err := conf.GetPGBouncerDBs()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Configuration.GetPGBouncerDBs() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Configuration.GetPGBouncerDBs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfiguration_GetPGBouncerUsers(t *testing.T) {
+	fifty := 50
+
+	tests := []struct {
+		name    string
+		config  string
+		want    *PGBouncerUsers
+		wantErr bool
+	}{
+		{
+			name:   "Unset",
+			config: "credentials:\n    host: localhost\n",
+		},
+		{
+			name:   "Defaults",
+			config: "pgbouncer_users:\n    from_comments: true\n    users:\n        app:\n            pool_mode: transaction\n            max_user_connections: 50\n",
+			want: &PGBouncerUsers{
+				File:         DefaultUsersFile,
+				Remote:       DefaultUsersRemote,
+				FromComments: true,
+				Users: map[string]*pgbouncerini.UserSettings{
+					"app": {PoolMode: "transaction", MaxUserConnections: &fifty},
+				},
+			},
+		},
+		{
+			name:    "Invalid pool mode",
+			config:  "pgbouncer_users:\n    users:\n        app:\n            pool_mode: txn\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &Configuration{
+				configStream: strings.NewReader(tt.config),
+			}
+			got, err := conf.GetPGBouncerUsers()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Configuration.GetPGBouncerUsers() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Configuration.GetPGBouncerUsers() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfiguration_GetHBASettings(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		want    *HBASettings
+		wantErr bool
+	}{
+		{
+			name:   "Unset",
+			config: "credentials:\n    host: localhost\n",
+		},
+		{
+			name:   "Defaults",
+			config: "hba:\n    rules:\n        - type: hostssl\n          role: [app_users]\n          address: 10.0.0.0/8\n          method: scram-sha-256\n",
+			want: &HBASettings{
+				File:   DefaultHBAFile,
+				Remote: DefaultHBARemote,
+				Rules: []*hba.Rule{
+					{Type: "hostssl", Role: []string{"app_users"}, Address: "10.0.0.0/8", Method: "scram-sha-256"},
+				},
+			},
+		},
+		{
+			name:   "Empty entries",
+			config: "hba:\n    rules:\n        -\n        - null\n        - type: local\n          method: peer\n",
+			want: &HBASettings{
+				File:   DefaultHBAFile,
+				Remote: DefaultHBARemote,
+				Rules:  []*hba.Rule{{Type: "local", Method: "peer"}},
+			},
+		},
+		{
+			name:    "Invalid rule",
+			config:  "hba:\n    rules:\n        - type: host\n          address: db.example.com\n          method: md5\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &Configuration{
+				configStream: strings.NewReader(tt.config),
+			}
+			got, err := conf.GetHBASettings()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Configuration.GetHBASettings() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Configuration.GetHBASettings() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfiguration_GetFilePermissions(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		pgHost  *PGBouncerHost
+		want    *sendfile.Permissions
+		wantErr bool
+	}{
+		{
+			name:   "Unset",
+			config: "credentials:\n    host: localhost\n",
+			pgHost: &PGBouncerHost{Host: "pgbouncer-01"},
+			want:   &sendfile.Permissions{},
+		},
+		{
+			name:   "Global",
+			config: "files:\n    owner: postgres\n    group: postgres\n    mode: 0640\n",
+			pgHost: &PGBouncerHost{Host: "pgbouncer-01"},
+			want:   &sendfile.Permissions{Owner: "postgres", Group: "postgres", Mode: 0640},
+		},
+		{
+			name:   "Host override",
+			config: "files:\n    owner: postgres\n    group: postgres\n    mode: \"0640\"\n",
+			pgHost: &PGBouncerHost{Host: "pgbouncer-01", Files: &FilePermissions{Group: "pgbouncer", Mode: "600"}},
+			want:   &sendfile.Permissions{Owner: "postgres", Group: "pgbouncer", Mode: 0600},
+		},
+		{
+			name:    "Invalid mode",
+			config:  "files:\n    mode: rw-r-----\n",
+			pgHost:  &PGBouncerHost{Host: "pgbouncer-01"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &Configuration{
+				configStream: strings.NewReader(tt.config),
+			}
+			got, err := conf.GetFilePermissions(tt.pgHost)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Configuration.GetFilePermissions() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Configuration.GetFilePermissions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfiguration_GetPGBouncerHost_JumpHosts(t *testing.T) {
+	config := `
+hosts:
+    - host: pgbouncer-01
+      port: 22
+      username: ansible
+      privkey: hostkey
+    -
+    - host: pgbouncer-02
+      port: 2222
+      username: ansible
+      privkey: hostkey
+      jump_hosts:
+        - host: dmz-bastion
+          port: 2200
+          username: jump
+          privkey: jumpkey
+jump_hosts:
+    - host: bastion
+    - null
+    - host: inner-bastion
+      username: inner
+`
+	conf := &Configuration{
+		configStream: strings.NewReader(config),
+	}
+
+	hosts, err := conf.GetPGBouncerHost()
+	if err != nil {
+		t.Errorf("Configuration.GetPGBouncerHost() error = %v", err)
+		return
+	}
+	if len(hosts) != 2 {
+		t.Errorf("Configuration.GetPGBouncerHost() returned %d hosts, want 2", len(hosts))
+		return
+	}
+
+	tests := []struct {
+		name string
+		host *PGBouncerHost
+		want []*sendfile.Hop
+	}{
+		{
+			name: "Global chain with host defaults",
+			host: hosts[0],
+			want: []*sendfile.Hop{
+				{Addr: "bastion:22", Username: "ansible", PrivKey: []byte("hostkey")},
+				{Addr: "inner-bastion:22", Username: "inner", PrivKey: []byte("hostkey")},
+			},
+		},
+		{
+			name: "Host chain",
+			host: hosts[1],
+			want: []*sendfile.Hop{
+				{Addr: "dmz-bastion:2200", Username: "jump", PrivKey: []byte("jumpkey")},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.host.JumpHops(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PGBouncerHost.JumpHops() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Hosts are copies, the global chain is not shared
+	hosts[0].JumpHosts[0].Host = "changed"
+	if conf.JumpHosts[0].Host != "bastion" {
+		t.Errorf("Configuration.GetPGBouncerHost() shares the global jump hosts")
+	}
+}
+
+func TestPGBouncerHost_AdminConsole(t *testing.T) {
+	jump := []*JumpHost{{Host: "bastion", Port: 22}}
+	hostHop := &sendfile.Hop{Addr: "pgbouncer-01:22", Username: "ansible", PrivKey: []byte("hostkey")}
+	jumpHop := &sendfile.Hop{Addr: "bastion:22", Username: "ansible", PrivKey: []byte("hostkey")}
+
+	tests := []struct {
+		name      string
+		jumpHosts []*JumpHost
+		reload    *ReloadSettings
+		want      *AdminConsoleTarget
+		wantErr   bool
+	}{
+		{
+			name: "Direct",
+			want: &AdminConsoleTarget{Host: "pgbouncer-01", Port: 5432, Hops: []*sendfile.Hop{}},
+		},
+		{
+			name:      "Through jump hosts",
+			jumpHosts: jump,
+			reload:    &ReloadSettings{Port: 6432},
+			want:      &AdminConsoleTarget{Host: "pgbouncer-01", Port: 6432, Hops: []*sendfile.Hop{jumpHop}},
+		},
+		{
+			name:   "Tunnel to localhost",
+			reload: &ReloadSettings{Tunnel: true, Port: 6432},
+			want:   &AdminConsoleTarget{Host: "127.0.0.1", Port: 6432, Hops: []*sendfile.Hop{hostHop}, Tunnel: true},
+		},
+		{
+			name:      "Tunnel to unix socket through jump hosts",
+			jumpHosts: jump,
+			reload:    &ReloadSettings{Tunnel: true, Host: "/var/run/postgresql"},
+			want:      &AdminConsoleTarget{Host: "/var/run/postgresql", Port: 5432, Hops: []*sendfile.Hop{jumpHop, hostHop}, Tunnel: true},
+		},
+		{
+			name:    "Unix socket without tunnel",
+			reload:  &ReloadSettings{Host: "/var/run/postgresql"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &PGBouncerHost{
+				Host:      "pgbouncer-01",
+				Port:      22,
+				UserName:  "ansible",
+				PrivKey:   "hostkey",
+				JumpHosts: tt.jumpHosts,
+				Reload:    tt.reload,
+			}
+			got, err := h.AdminConsole()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("PGBouncerHost.AdminConsole() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PGBouncerHost.AdminConsole() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReloadSettings_Script(t *testing.T) {
+	tests := []struct {
+		name    string
+		reload  *ReloadSettings
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "Unset",
+			want: "",
+		},
+		{
+			name:   "Admin console",
+			reload: &ReloadSettings{Method: "admin-console", Tunnel: true},
+			want:   "",
+		},
+		{
+			name:   "Systemctl default unit",
+			reload: &ReloadSettings{Method: "systemctl"},
+			want:   "sudo systemctl reload 'pgbouncer'",
+		},
+		{
+			name:   "Systemctl unit",
+			reload: &ReloadSettings{Method: "systemctl", Unit: "pgbouncer@6432"},
+			want:   "sudo systemctl reload 'pgbouncer@6432'",
+		},
+		{
+			name:   "Kill default pidfile",
+			reload: &ReloadSettings{Method: "kill"},
+			want:   `sudo kill -HUP "$(cat -- '/var/run/pgbouncer/pgbouncer.pid')"`,
+		},
+		{
+			name:   "Docker",
+			reload: &ReloadSettings{Method: "docker", Container: "pgbouncer"},
+			want:   "sudo docker kill -s HUP 'pgbouncer'",
+		},
+		{
+			name:    "Docker without container",
+			reload:  &ReloadSettings{Method: "docker"},
+			wantErr: true,
+		},
+		{
+			name:   "Command",
+			reload: &ReloadSettings{Method: "command", Command: "/usr/local/bin/reload-pgbouncer --all"},
+			want:   "/usr/local/bin/reload-pgbouncer --all",
+		},
+		{
+			name:    "Command unset",
+			reload:  &ReloadSettings{Method: "command"},
+			wantErr: true,
+		},
+		{
+			name:    "Unknown method",
+			reload:  &ReloadSettings{Method: "restart"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.reload.Script()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ReloadSettings.Script() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ReloadSettings.Script() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
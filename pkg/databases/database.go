@@ -1,21 +1,75 @@
 package databases
 
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
 const DefaultQuery = "select rolname,rolpassword from pg_authid where rolpassword is not null order by rolname asc"
 
 type Databases interface {
 	// Query results to a map
-	ToMap(query string) (map[string]string, error)
+	ToMap(ctx context.Context, query string) (map[string]string, error)
+	// Pass query results row by row to fn, in query order
+	Stream(ctx context.Context, query string, fn func(key, value string) error) error
+	// Query results to a list of column name to value maps
+	ToRecords(ctx context.Context, query string) ([]map[string]sql.NullString, error)
 	// Exec query with no results excepted
-	ToVoid(query string) error
+	ToVoid(ctx context.Context, query string) error
 	Close()
 }
 
-func NewQuery(dsn string) (Databases, error) {
+// Option tunes the connection returned by NewQuery.
+type Option func(*Postgres)
+
+// WithConnectTimeout bounds each connection attempt.
+func WithConnectTimeout(timeout time.Duration) Option {
+	return func(p *Postgres) {
+		p.connectTimeout = timeout
+	}
+}
+
+// WithStatementTimeout bounds each statement, rows reading included.
+func WithStatementTimeout(timeout time.Duration) Option {
+	return func(p *Postgres) {
+		p.statementTimeout = timeout
+	}
+}
+
+// WithRetries sets how many times a transient error is retried and the
+// first backoff delay, doubled after each attempt.
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(p *Postgres) {
+		p.retries = retries
+		p.retryBackoff = backoff
+	}
+}
+
+// WithDialer opens the connections with dialer, closed along with the
+// connection when it is an io.Closer.
+func WithDialer(dialer pq.Dialer) Option {
+	return func(p *Postgres) {
+		p.dialer = dialer
+	}
+}
+
+func NewQuery(ctx context.Context, dsn string, opts ...Option) (Databases, error) {
 	cred := Postgres{
-		dsn: dsn,
+		dsn:              dsn,
+		connectTimeout:   DefaultConnectTimeout,
+		statementTimeout: DefaultStatementTimeout,
+		retries:          DefaultRetries,
+		retryBackoff:     DefaultRetryBackoff,
+	}
+
+	for _, opt := range opts {
+		opt(&cred)
 	}
 
-	if err := cred.connect(); err != nil {
+	if err := cred.connect(ctx); err != nil {
 		return nil, err
 	}
 
@@ -0,0 +1,116 @@
+package databases
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultNotifyChannel = "pgbouncer_updater"
+	NotifyFunction       = "pgbouncer_updater_notify"
+
+	minReconnectInterval = time.Second
+	maxReconnectInterval = time.Minute
+	listenerPingInterval = 90 * time.Second
+
+	notifyFunctionSQL = `CREATE OR REPLACE FUNCTION public.%[1]s(reason text DEFAULT '')
+RETURNS void
+LANGUAGE sql
+AS $$ SELECT pg_notify(%[2]s, reason) $$`
+)
+
+// Notification is sent for every NOTIFY received on the watched channel.
+// Reconnected is set instead when the connection was re-established, as
+// notifications sent in between are lost.
+type Notification struct {
+	Payload     string
+	Reconnected bool
+}
+
+type Listener interface {
+	Notifications() <-chan Notification
+	Close()
+}
+
+type PostgresListener struct {
+	listener      *pq.Listener
+	notifications chan Notification
+	cancel        context.CancelFunc
+}
+
+// NewListener holds a connection to dsn and runs LISTEN on channel until ctx
+// is done or Close is called.
+func NewListener(ctx context.Context, dsn, channel string) (Listener, error) {
+	listener := pq.NewListener(dsn, minReconnectInterval, maxReconnectInterval, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Warn("Listener connection event ", event, ": ", err)
+		}
+	})
+
+	if err := listener.Listen(channel); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	l := &PostgresListener{
+		listener:      listener,
+		notifications: make(chan Notification),
+		cancel:        cancel,
+	}
+
+	go l.forward(ctx)
+
+	return l, nil
+}
+
+func (l *PostgresListener) Notifications() <-chan Notification {
+	return l.notifications
+}
+
+func (l *PostgresListener) Close() {
+	l.cancel()
+}
+
+func (l *PostgresListener) forward(ctx context.Context) {
+	defer close(l.notifications)
+	defer l.listener.Close()
+
+	ping := time.NewTicker(listenerPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ping.C:
+			// Detect a dead connection, the listener reconnects by itself
+			go l.listener.Ping()
+
+		case n := <-l.listener.Notify:
+			notification := Notification{Reconnected: true}
+			if n != nil {
+				notification = Notification{Payload: n.Extra}
+			}
+
+			select {
+			case l.notifications <- notification:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// NotifyFunctionSQL returns the statement creating the function role
+// management scripts call to signal a change:
+//
+//	SELECT pgbouncer_updater_notify('password reset for app');
+func NotifyFunctionSQL(channel string) string {
+	return fmt.Sprintf(notifyFunctionSQL, NotifyFunction, pq.QuoteLiteral(channel))
+}
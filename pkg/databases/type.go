@@ -1,70 +1,180 @@
 package databases
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	DefaultConnectTimeout   = 10 * time.Second
+	DefaultStatementTimeout = 30 * time.Second
+	DefaultRetries          = 3
+	DefaultRetryBackoff     = 500 * time.Millisecond
+
+	maxRetryBackoff = 30 * time.Second
+)
+
 type Postgres struct {
-	dsn  string
-	conn *sql.DB
+	dsn              string
+	conn             *sql.DB
+	connectTimeout   time.Duration
+	statementTimeout time.Duration
+	retries          int
+	retryBackoff     time.Duration
+	dialer           pq.Dialer
 }
 
-func (p *Postgres) ToMap(query string) (map[string]string, error) {
-	defer p.Close()
+func (p *Postgres) ToMap(ctx context.Context, query string) (map[string]string, error) {
+	var data map[string]string
+
+	err := p.withRetry(ctx, p.statementTimeout, func(ctx context.Context) error {
+		// Execute query from provider connection
+		rows, err := p.execQuery(ctx, query)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		// Parse query into Map[string]string
+		data = make(map[string]string)
+		for rows.Next() {
+			var key, value string
 
-	// Execute query from provider connection
-	rows, err := p.execQuery(query)
+			if err := rows.Scan(&key, &value); err != nil {
+				return err
+			}
+			data[key] = value
+		}
+
+		return rows.Err()
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	// Parse query into Map[string]string
-	data := make(map[string]string)
-	for rows.Next() {
-		var key, value string
+	return data, nil
+}
+
+func (p *Postgres) ToRecords(ctx context.Context, query string) ([]map[string]sql.NullString, error) {
+	var data []map[string]sql.NullString
 
-		if err := rows.Scan(&key, &value); err != nil {
-			return nil, err
+	err := p.withRetry(ctx, p.statementTimeout, func(ctx context.Context) error {
+		// Execute query from provider connection
+		rows, err := p.execQuery(ctx, query)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		columns, err := rows.Columns()
+		if err != nil {
+			return err
 		}
-		data[key] = value
-	}
 
-	if err := rows.Err(); err != nil {
+		// Parse each row into a column name to value map
+		data = []map[string]sql.NullString{}
+		for rows.Next() {
+			values := make([]sql.NullString, len(columns))
+			dest := make([]interface{}, len(columns))
+			for i := range values {
+				dest[i] = &values[i]
+			}
+
+			if err := rows.Scan(dest...); err != nil {
+				return err
+			}
+
+			record := make(map[string]sql.NullString, len(columns))
+			for i, column := range columns {
+				record[column] = values[i]
+			}
+			data = append(data, record)
+		}
+
+		return rows.Err()
+	})
+	if err != nil {
 		return nil, err
 	}
 
 	return data, nil
 }
 
-func (p *Postgres) ToVoid(query string) error {
-	defer p.Close()
+// Stream calls fn for each row without loading the whole result in memory.
+// The query is only retried until the first row reached fn.
+func (p *Postgres) Stream(ctx context.Context, query string, fn func(key, value string) error) error {
+	return p.withRetry(ctx, p.statementTimeout, func(ctx context.Context) error {
+		// Execute query from provider connection
+		rows, err := p.execQuery(ctx, query)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
 
-	// Execute query from provider connection
-	res, err := p.execQuery(query)
-	if err != nil {
-		return err
-	}
-	defer res.Close()
+		started := false
+		for rows.Next() {
+			var key, value string
 
-	return nil
+			if err := rows.Scan(&key, &value); err != nil {
+				return &permanentError{err}
+			}
+
+			started = true
+			if err := fn(key, value); err != nil {
+				return &permanentError{err}
+			}
+		}
+
+		if err := rows.Err(); err != nil {
+			if started {
+				return &permanentError{err}
+			}
+			return err
+		}
+
+		return nil
+	})
+}
+
+func (p *Postgres) ToVoid(ctx context.Context, query string) error {
+	return p.withRetry(ctx, p.statementTimeout, func(ctx context.Context) error {
+		// Execute query from provider connection
+		res, err := p.execQuery(ctx, query)
+		if err != nil {
+			return err
+		}
+		defer res.Close()
+
+		return res.Err()
+	})
 }
 
 func (p *Postgres) Close() {
-	p.conn.Close()
+	if p.conn != nil {
+		p.conn.Close()
+	}
+
+	if closer, ok := p.dialer.(io.Closer); ok {
+		closer.Close()
+	}
 }
 
-func (p *Postgres) execQuery(query string) (*sql.Rows, error) {
-	err := p.conn.Ping()
+func (p *Postgres) execQuery(ctx context.Context, query string) (*sql.Rows, error) {
+	err := p.conn.PingContext(ctx)
 	if err != nil {
 		return nil, err
 	}
 
 	// Execute a simple query
-	rows, err := p.conn.Query(query)
+	rows, err := p.conn.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -74,13 +184,135 @@ func (p *Postgres) execQuery(query string) (*sql.Rows, error) {
 
 }
 
-func (p *Postgres) connect() error {
+func (p *Postgres) connect(ctx context.Context) error {
 	var err error
 
-	p.conn, err = sql.Open("postgres", p.dsn)
-	if err := p.conn.Ping(); err != nil {
-		log.Error("PGBouncer SQL Ping ", p.conn.Ping())
+	p.conn, err = p.open()
+	if err != nil {
+		p.Close()
+		return err
+	}
+
+	err = p.withRetry(ctx, p.connectTimeout, func(ctx context.Context) error {
+		return p.conn.PingContext(ctx)
+	})
+	if err != nil {
+		log.Error("PGBouncer SQL Ping ", err)
+		p.Close()
+		return err
+	}
+
+	return nil
+}
+
+func (p *Postgres) open() (*sql.DB, error) {
+	if p.dialer == nil {
+		return sql.Open("postgres", p.dsn)
+	}
+
+	connector, err := pq.NewConnector(p.dsn)
+	if err != nil {
+		return nil, err
+	}
+	connector.Dialer(p.dialer)
+
+	return sql.OpenDB(connector), nil
+}
+
+// withRetry runs fn with a per attempt timeout and retries it with an
+// exponential backoff as long as the returned error is transient.
+func (p *Postgres) withRetry(ctx context.Context, timeout time.Duration, fn func(ctx context.Context) error) error {
+	backoff := p.retryBackoff
+	for attempt := 0; ; attempt++ {
+		err := runWithTimeout(ctx, timeout, fn)
+		if err == nil {
+			return nil
+		}
+
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			return permanent.err
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if attempt >= p.retries || !IsTransient(err) {
+			return err
+		}
+
+		log.Warn("Transient database error, retry in ", backoff, ": ", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+// permanentError stops withRetry, fn already had side effects.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func runWithTimeout(ctx context.Context, timeout time.Duration, fn func(ctx context.Context) error) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	return fn(ctx)
+}
+
+// IsTransient reports whether err is worth a retry: network failures, per
+// attempt timeouts and the PostgreSQL errors raised while a server is
+// starting, shutting down or out of connection slots.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		// connection_exception, insufficient_resources
+		case "08", "53":
+			return true
+		}
+
+		switch pqErr.Code {
+		// admin_shutdown, crash_shutdown, cannot_connect_now
+		case "57P01", "57P02", "57P03":
+			return true
+		}
 	}
 
-	return err
+	return false
 }
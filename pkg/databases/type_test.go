@@ -1,13 +1,18 @@
 package databases
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"log"
+	"net"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -45,7 +50,7 @@ func TestPostgres_ToMap(t *testing.T) {
 
 	mock.ExpectQuery(query).WillReturnRows(rows)
 
-	roles, err := repo.ToMap(query)
+	roles, err := repo.ToMap(context.Background(), query)
 	assert.NotNil(t, roles)
 	assert.NoError(t, err)
 
@@ -67,6 +72,180 @@ func TestPostgres_ToVoid(t *testing.T) {
 
 	mock.ExpectQuery(query).WillReturnRows(rows)
 
-	 err := repo.ToVoid(query)
+	err := repo.ToVoid(context.Background(), query)
 	assert.NoError(t, err)
 }
+
+func TestPostgres_ToMapRetry(t *testing.T) {
+	db, mock := NewMock()
+	repo := Postgres{
+		dsn:          "sqlmock_db_0",
+		conn:         db,
+		retries:      2,
+		retryBackoff: time.Millisecond,
+	}
+
+	query := "select rolname,rolpassword from pg_authid where rolpassword is not null order by rolname asc"
+
+	rows := sqlmock.NewRows([]string{"rolname", "rolpassword"}).AddRow(pg_authid.rolname, pg_authid.rolpassword)
+
+	mock.ExpectQuery(query).WillReturnError(&net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")})
+	mock.ExpectQuery(query).WillReturnRows(rows)
+
+	roles, err := repo.ToMap(context.Background(), query)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"postgres": "postgres"}, roles)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgres_ToMapNoRetry(t *testing.T) {
+	db, mock := NewMock()
+	repo := Postgres{
+		dsn:          "sqlmock_db_0",
+		conn:         db,
+		retries:      2,
+		retryBackoff: time.Millisecond,
+	}
+
+	query := "select rolname,rolpassword from pg_authid where rolpassword is not null order by rolname asc"
+
+	mock.ExpectQuery(query).WillReturnError(&pq.Error{Code: "42501"})
+
+	_, err := repo.ToMap(context.Background(), query)
+	assert.Error(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgres_ToVoidCanceled(t *testing.T) {
+	db, _ := NewMock()
+	repo := Postgres{
+		dsn:          "sqlmock_db_0",
+		conn:         db,
+		retries:      2,
+		retryBackoff: time.Millisecond,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := repo.ToVoid(ctx, "reload")
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "bad conn", err: driver.ErrBadConn, want: true},
+		{name: "timeout", err: context.DeadlineExceeded, want: true},
+		{name: "canceled", err: context.Canceled, want: false},
+		{name: "cannot connect now", err: &pq.Error{Code: "57P03"}, want: true},
+		{name: "too many connections", err: &pq.Error{Code: "53300"}, want: true},
+		{name: "connection failure", err: &pq.Error{Code: "08006"}, want: true},
+		{name: "permission denied", err: &pq.Error{Code: "42501"}, want: false},
+		{name: "other", err: errors.New("syntax error"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsTransient(tt.err); got != tt.want {
+				t.Errorf("IsTransient(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNotifyFunctionSQL(t *testing.T) {
+	got := NotifyFunctionSQL("it's")
+	assert.Contains(t, got, "FUNCTION public.pgbouncer_updater_notify(reason text DEFAULT '')")
+	assert.Contains(t, got, "pg_notify('it''s', reason)")
+}
+
+func TestPostgres_Stream(t *testing.T) {
+	db, mock := NewMock()
+	repo := Postgres{
+		dsn:          "sqlmock_db_0",
+		conn:         db,
+		retries:      2,
+		retryBackoff: time.Millisecond,
+	}
+
+	query := "select rolname,rolpassword from pg_authid where rolpassword is not null order by rolname asc"
+
+	rows := sqlmock.NewRows([]string{"rolname", "rolpassword"}).
+		AddRow("app", "md5app").
+		AddRow("postgres", "md5postgres")
+
+	mock.ExpectQuery(query).WillReturnError(&net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")})
+	mock.ExpectQuery(query).WillReturnRows(rows)
+
+	got := []string{}
+	err := repo.Stream(context.Background(), query, func(key, value string) error {
+		got = append(got, key+"="+value)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"app=md5app", "postgres=md5postgres"}, got)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgres_StreamNoRetryAfterFirstRow(t *testing.T) {
+	db, mock := NewMock()
+	repo := Postgres{
+		dsn:          "sqlmock_db_0",
+		conn:         db,
+		retries:      2,
+		retryBackoff: time.Millisecond,
+	}
+
+	query := "select rolname,rolpassword from pg_authid where rolpassword is not null order by rolname asc"
+
+	rows := sqlmock.NewRows([]string{"rolname", "rolpassword"}).
+		AddRow("app", "md5app").
+		AddRow("postgres", "md5postgres").
+		RowError(1, &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")})
+
+	mock.ExpectQuery(query).WillReturnRows(rows)
+
+	calls := 0
+	err := repo.Stream(context.Background(), query, func(key, value string) error {
+		calls++
+		return nil
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, calls)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgres_ToRecords(t *testing.T) {
+	db, mock := NewMock()
+	repo := Postgres{
+		dsn:  "sqlmock_db_0",
+		conn: db,
+	}
+
+	query := "show config"
+
+	rows := sqlmock.NewRows([]string{"key", "value", "changeable"}).
+		AddRow("auth_type", "md5", "yes").
+		AddRow("auth_file", nil, "yes")
+
+	mock.ExpectQuery(query).WillReturnRows(rows)
+
+	got, err := repo.ToRecords(context.Background(), query)
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]sql.NullString{
+		{
+			"key":        {String: "auth_type", Valid: true},
+			"value":      {String: "md5", Valid: true},
+			"changeable": {String: "yes", Valid: true},
+		},
+		{
+			"key":        {String: "auth_file", Valid: true},
+			"value":      {},
+			"changeable": {String: "yes", Valid: true},
+		},
+	}, got)
+}
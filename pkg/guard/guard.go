@@ -0,0 +1,20 @@
+package guard
+
+import "gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/userlist"
+
+type Guards interface {
+	// Check returns an ErrViolation listing every guard the new userlist
+	// breaks when replacing the current one. With a nil current list only
+	// the guards on the new one run
+	Check(current, next []*userlist.User) error
+}
+
+func NewGuard(policy *Policy) Guards {
+	if policy == nil {
+		policy = new(Policy)
+	}
+
+	return &Guard{
+		policy: policy,
+	}
+}
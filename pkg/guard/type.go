@@ -0,0 +1,78 @@
+package guard
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/userlist"
+)
+
+var (
+	ErrViolation = errors.New("userlist safety guard violated, use --force to push anyway")
+)
+
+// Policy bounds what a push may do to a remote userlist, unset fields
+// disable their guard.
+type Policy struct {
+	// Minimum number of roles in the new userlist
+	MinRoles int `yaml:"min_roles,omitempty"`
+	// Maximum number of roles removed from the current userlist
+	MaxRemoved *int `yaml:"max_removed,omitempty"`
+	// Maximum share of the current userlist removed, from 0 to 100
+	MaxRemovedPercent *float64 `yaml:"max_removed_percent,omitempty"`
+	// Roles the new userlist must always hold
+	ProtectedRoles []string `yaml:"protected_roles,omitempty"`
+}
+
+// ChecksRemovals reports whether a guard compares the new userlist with the
+// current one.
+func (p *Policy) ChecksRemovals() bool {
+	return p != nil && (p.MaxRemoved != nil || p.MaxRemovedPercent != nil)
+}
+
+type Guard struct {
+	policy *Policy
+}
+
+func (g *Guard) Check(current, next []*userlist.User) error {
+	issues := []string{}
+
+	nextNames := names(next)
+	if g.policy.MinRoles > 0 && len(nextNames) < g.policy.MinRoles {
+		issues = append(issues, fmt.Sprintf("%d roles, min_roles is %d", len(nextNames), g.policy.MinRoles))
+	}
+
+	removed := userlist.Diff(current, next).Removed
+	if g.policy.MaxRemoved != nil && len(removed) > *g.policy.MaxRemoved {
+		issues = append(issues, fmt.Sprintf("%d roles removed, max_removed is %d", len(removed), *g.policy.MaxRemoved))
+	}
+
+	if currentCount := len(names(current)); g.policy.MaxRemovedPercent != nil && currentCount > 0 {
+		percent := float64(len(removed)) * 100 / float64(currentCount)
+		if percent > *g.policy.MaxRemovedPercent {
+			issues = append(issues, fmt.Sprintf("%.1f%% of roles removed, max_removed_percent is %g", percent, *g.policy.MaxRemovedPercent))
+		}
+	}
+
+	for _, role := range g.policy.ProtectedRoles {
+		if _, found := nextNames[role]; !found {
+			issues = append(issues, fmt.Sprintf("protected role %s missing", role))
+		}
+	}
+
+	if len(issues) > 0 {
+		return fmt.Errorf("%w: %s", ErrViolation, strings.Join(issues, ", "))
+	}
+
+	return nil
+}
+
+func names(users []*userlist.User) map[string]struct{} {
+	set := make(map[string]struct{}, len(users))
+	for _, user := range users {
+		set[user.UserName] = struct{}{}
+	}
+
+	return set
+}
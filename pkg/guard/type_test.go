@@ -0,0 +1,142 @@
+package guard
+
+import (
+	"errors"
+	"testing"
+
+	"gitlab.infra.adelaidegroup.fr/infra/applications-sources/pgbouncer-updater/pkg/userlist"
+)
+
+func users(names ...string) []*userlist.User {
+	list := []*userlist.User{}
+	for _, name := range names {
+		list = append(list, &userlist.User{UserName: name, Md5: "md5" + name})
+	}
+
+	return list
+}
+
+func TestGuard_Check(t *testing.T) {
+	one := 1
+	half := 50.0
+
+	tests := []struct {
+		name    string
+		policy  *Policy
+		current []*userlist.User
+		next    []*userlist.User
+		wantErr bool
+	}{
+		{
+			name:    "No policy",
+			current: users("a", "b", "c"),
+			next:    users(),
+		},
+		{
+			name:    "Enough roles",
+			policy:  &Policy{MinRoles: 2},
+			current: users("a"),
+			next:    users("a", "b"),
+		},
+		{
+			name:    "Too few roles",
+			policy:  &Policy{MinRoles: 2},
+			current: users("a", "b"),
+			next:    users("a"),
+			wantErr: true,
+		},
+		{
+			name:    "Duplicates count once",
+			policy:  &Policy{MinRoles: 2},
+			current: users(),
+			next:    users("a", "a"),
+			wantErr: true,
+		},
+		{
+			name:    "Unknown current list, new list guards still run",
+			policy:  &Policy{MinRoles: 2, MaxRemoved: &one},
+			next:    users("a"),
+			wantErr: true,
+		},
+		{
+			name:    "Removed under max",
+			policy:  &Policy{MaxRemoved: &one},
+			current: users("a", "b", "c"),
+			next:    users("a", "c", "d"),
+		},
+		{
+			name:    "Removed over max",
+			policy:  &Policy{MaxRemoved: &one},
+			current: users("a", "b", "c"),
+			next:    users("a"),
+			wantErr: true,
+		},
+		{
+			name:    "Removed under percent",
+			policy:  &Policy{MaxRemovedPercent: &half},
+			current: users("a", "b", "c", "d"),
+			next:    users("a", "b"),
+		},
+		{
+			name:    "Removed over percent",
+			policy:  &Policy{MaxRemovedPercent: &half},
+			current: users("a", "b", "c", "d"),
+			next:    users("a"),
+			wantErr: true,
+		},
+		{
+			name:    "Percent of empty current",
+			policy:  &Policy{MaxRemovedPercent: &half},
+			current: users(),
+			next:    users("a"),
+		},
+		{
+			name:    "Protected role present",
+			policy:  &Policy{ProtectedRoles: []string{"pgbouncer"}},
+			current: users(),
+			next:    users("app", "pgbouncer"),
+		},
+		{
+			name:    "Protected role missing",
+			policy:  &Policy{ProtectedRoles: []string{"pgbouncer"}},
+			current: users("app", "pgbouncer"),
+			next:    users("app"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewGuard(tt.policy).Check(tt.current, tt.next)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Guard.Check() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil && !errors.Is(err, ErrViolation) {
+				t.Errorf("Guard.Check() error = %v, want %v", err, ErrViolation)
+			}
+		})
+	}
+}
+
+func TestPolicy_ChecksRemovals(t *testing.T) {
+	maxRemoved := 0
+	maxRemovedPercent := 10.0
+
+	tests := []struct {
+		name   string
+		policy *Policy
+		want   bool
+	}{
+		{name: "No policy"},
+		{name: "New list guards only", policy: &Policy{MinRoles: 1, ProtectedRoles: []string{"postgres"}}},
+		{name: "Max removed", policy: &Policy{MaxRemoved: &maxRemoved}, want: true},
+		{name: "Max removed percent", policy: &Policy{MaxRemovedPercent: &maxRemovedPercent}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.ChecksRemovals(); got != tt.want {
+				t.Errorf("Policy.ChecksRemovals() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package hba
+
+import "io"
+
+// MembersQuery returns the login roles belonging to each role, directly or
+// through other roles, PGBouncer HBA files don't support +role.
+const MembersQuery = `with recursive members(roleid, member) as (
+	select roleid, member from pg_auth_members
+	union
+	select members.roleid, pg_auth_members.member from members join pg_auth_members on pg_auth_members.roleid = members.member
+)
+select role.rolname as role, member.rolname as member from members
+	join pg_roles role on role.oid = members.roleid
+	join pg_roles member on member.oid = members.member
+where member.rolcanlogin order by 1, 2`
+
+type Files interface {
+	// AddMember records that member belongs to role, as read by MembersQuery
+	AddMember(role, member string)
+	// NeedMembers is true when a rule matches users by role
+	NeedMembers() bool
+	// Write fails with ErrInvalidName when a rule matches a member whose
+	// name can't be written unquoted
+	Write(w io.Writer) error
+}
+
+// NewFile renders rules as a PGBouncer auth_hba_file, rules must have been
+// validated with Validate.
+func NewFile(rules []*Rule) Files {
+	return &File{
+		rules:   rules,
+		members: map[string]map[string]struct{}{},
+	}
+}
@@ -0,0 +1,194 @@
+package hba
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	header = "# generated by pgbouncer-updater, do not edit\n"
+	all    = "all"
+)
+
+var (
+	ErrInvalidRule = errors.New("invalid hba rule")
+	ErrInvalidName = errors.New("invalid member name")
+
+	plainName = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+	types     = []string{"local", "host", "hostssl", "hostnossl"}
+	methods   = []string{"trust", "reject", "peer", "password", "md5", "scram-sha-256", "cert"}
+)
+
+// Rule is one auth_hba_file line, users and role members are matched.
+type Rule struct {
+	Type string `yaml:"type"`
+	// Database names, all when empty
+	Database []string `yaml:"database,omitempty"`
+	// User names, all when both user and role are empty
+	User []string `yaml:"user,omitempty"`
+	// Roles whose login members are matched
+	Role []string `yaml:"role,omitempty"`
+	// CIDR or all, unset for local rules
+	Address string `yaml:"address,omitempty"`
+	Method  string `yaml:"method"`
+}
+
+type File struct {
+	rules   []*Rule
+	members map[string]map[string]struct{}
+}
+
+func (f *File) AddMember(role, member string) {
+	if f.members[role] == nil {
+		f.members[role] = map[string]struct{}{}
+	}
+	f.members[role][member] = struct{}{}
+}
+
+func (f *File) NeedMembers() bool {
+	for _, rule := range f.rules {
+		if len(rule.Role) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Write renders the rules in order, a rule whose roles have no login
+// member and without users is left as a comment. Dropping a member that
+// can't be written would let it match the following rules, Write fails
+// instead and the error wraps ErrInvalidName.
+func (f *File) Write(w io.Writer) error {
+	buf := bufio.NewWriter(w)
+	buf.WriteString(header)
+
+	for i, rule := range f.rules {
+		users, err := f.users(rule)
+		if err != nil {
+			return fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		if len(users) == 0 {
+			fmt.Fprintf(buf, "# rule %d skipped: no login member in %s\n", i+1, strings.Join(rule.Role, ","))
+			continue
+		}
+
+		fields := []string{rule.Type, orAll(rule.Database), strings.Join(users, ",")}
+		if rule.Type != "local" {
+			fields = append(fields, rule.Address)
+		}
+		fields = append(fields, rule.Method)
+
+		buf.WriteString(strings.Join(fields, " ") + "\n")
+	}
+
+	return buf.Flush()
+}
+
+func (f *File) users(rule *Rule) ([]string, error) {
+	if len(rule.User) == 0 && len(rule.Role) == 0 {
+		return []string{all}, nil
+	}
+
+	set := map[string]struct{}{}
+	for _, user := range rule.User {
+		set[user] = struct{}{}
+	}
+	for _, role := range rule.Role {
+		for member := range f.members[role] {
+			// Members are written unquoted, all would match every user
+			if !plainName.MatchString(member) || member == all {
+				return nil, fmt.Errorf("%w: %q in %s can't be used unquoted", ErrInvalidName, member, role)
+			}
+			set[member] = struct{}{}
+		}
+	}
+
+	if _, found := set[all]; found {
+		return []string{all}, nil
+	}
+
+	users := make([]string, 0, len(set))
+	for user := range set {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+
+	return users, nil
+}
+
+// Validate checks rules against what PGBouncer HBA files support.
+func Validate(rules []*Rule) error {
+	for i, rule := range rules {
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("%w %d: %s", ErrInvalidRule, i+1, err)
+		}
+	}
+
+	return nil
+}
+
+func (r *Rule) validate() error {
+	if !contains(types, r.Type) {
+		return fmt.Errorf("type must be one of %s, not %q", strings.Join(types, ", "), r.Type)
+	}
+
+	if !contains(methods, r.Method) {
+		return fmt.Errorf("method must be one of %s, not %q", strings.Join(methods, ", "), r.Method)
+	}
+
+	for _, name := range append(append(append([]string{}, r.Database...), r.User...), r.Role...) {
+		if !plainName.MatchString(name) {
+			return fmt.Errorf("%q can't be used unquoted", name)
+		}
+	}
+
+	if contains(r.Role, all) {
+		return fmt.Errorf("role can't be all, use user")
+	}
+
+	if r.Type == "local" {
+		if r.Address != "" {
+			return fmt.Errorf("local rules have no address")
+		}
+		return nil
+	}
+
+	if r.Method == "peer" {
+		return fmt.Errorf("peer only works with local rules")
+	}
+
+	if r.Address == all {
+		return nil
+	}
+
+	if _, _, err := net.ParseCIDR(r.Address); err != nil {
+		return fmt.Errorf("address must be all or a CIDR, not %q", r.Address)
+	}
+
+	return nil
+}
+
+func orAll(names []string) string {
+	if len(names) == 0 {
+		return all
+	}
+
+	return strings.Join(names, ",")
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
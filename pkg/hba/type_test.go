@@ -0,0 +1,129 @@
+package hba
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestFile_Write(t *testing.T) {
+	rules := []*Rule{
+		{Type: "local", Method: "peer"},
+		{Type: "hostssl", Database: []string{"app", "billing"}, User: []string{"admin"}, Role: []string{"app_users"}, Address: "10.0.0.0/8", Method: "scram-sha-256"},
+		{Type: "host", Role: []string{"nobody"}, Address: "all", Method: "md5"},
+		{Type: "host", Address: "0.0.0.0/0", Method: "reject"},
+	}
+
+	file := NewFile(rules)
+	if !file.NeedMembers() {
+		t.Errorf("File.NeedMembers() = false, want true")
+	}
+	file.AddMember("app_users", "bob")
+	file.AddMember("app_users", "alice")
+	file.AddMember("app_users", "admin")
+	file.AddMember("other", "eve")
+
+	w := new(bytes.Buffer)
+	if err := file.Write(w); err != nil {
+		t.Errorf("File.Write() error = %v", err)
+		return
+	}
+
+	want := "# generated by pgbouncer-updater, do not edit\n" +
+		"local all all peer\n" +
+		"hostssl app,billing admin,alice,bob 10.0.0.0/8 scram-sha-256\n" +
+		"# rule 3 skipped: no login member in nobody\n" +
+		"host all all 0.0.0.0/0 reject\n"
+	if got := w.String(); got != want {
+		t.Errorf("File.Write() = %q, want %q", got, want)
+	}
+}
+
+func TestFile_Write_InvalidMember(t *testing.T) {
+	tests := []struct {
+		name    string
+		member  string
+		wantErr bool
+	}{
+		{name: "Plain", member: "bob"},
+		{name: "Dotted", member: "bob.smith"},
+		{name: "Space", member: "bob smith", wantErr: true},
+		{name: "Comma", member: "bob,eve", wantErr: true},
+		{name: "Quote", member: `bob"`, wantErr: true},
+		{name: "All", member: "all", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Dropping the member from the reject rule would let the next one
+			// accept it
+			file := NewFile([]*Rule{
+				{Type: "host", Role: []string{"blocked"}, Address: "all", Method: "reject"},
+				{Type: "host", Address: "0.0.0.0/0", Method: "md5"},
+			})
+			file.AddMember("blocked", tt.member)
+
+			w := new(bytes.Buffer)
+			err := file.Write(w)
+			if (err != nil) != tt.wantErr || (err != nil && !errors.Is(err, ErrInvalidName)) {
+				t.Errorf("File.Write() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+
+			want := header + "host all " + tt.member + " all reject\n" + "host all all 0.0.0.0/0 md5\n"
+			if got := w.String(); got != want {
+				t.Errorf("File.Write() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestFile_Write_UnusedInvalidMember(t *testing.T) {
+	file := NewFile([]*Rule{{Type: "host", Role: []string{"app_users"}, Address: "all", Method: "md5"}})
+	file.AddMember("app_users", "bob")
+	file.AddMember("other", "bob smith")
+
+	if err := file.Write(new(bytes.Buffer)); err != nil {
+		t.Errorf("File.Write() error = %v for a member of a role no rule uses", err)
+	}
+}
+
+func TestFile_NeedMembers(t *testing.T) {
+	if NewFile([]*Rule{{Type: "local", Method: "peer"}}).NeedMembers() {
+		t.Errorf("File.NeedMembers() = true, want false")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    *Rule
+		wantErr bool
+	}{
+		{name: "Local", rule: &Rule{Type: "local", Method: "peer"}},
+		{name: "Host all", rule: &Rule{Type: "host", Address: "all", Method: "md5"}},
+		{name: "Host ipv6", rule: &Rule{Type: "hostssl", User: []string{"app"}, Address: "fd00::/8", Method: "scram-sha-256"}},
+		{name: "Unknown type", rule: &Rule{Type: "hostgssenc", Address: "all", Method: "md5"}, wantErr: true},
+		{name: "Unknown method", rule: &Rule{Type: "host", Address: "all", Method: "ldap"}, wantErr: true},
+		{name: "Local address", rule: &Rule{Type: "local", Address: "10.0.0.0/8", Method: "md5"}, wantErr: true},
+		{name: "Host peer", rule: &Rule{Type: "host", Address: "all", Method: "peer"}, wantErr: true},
+		{name: "Host name", rule: &Rule{Type: "host", Address: "db.example.com", Method: "md5"}, wantErr: true},
+		{name: "Missing address", rule: &Rule{Type: "host", Method: "md5"}, wantErr: true},
+		{name: "Group syntax", rule: &Rule{Type: "local", User: []string{"+app"}, Method: "md5"}, wantErr: true},
+		{name: "Role all", rule: &Rule{Type: "local", Role: []string{"all"}, Method: "md5"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate([]*Rule{tt.rule})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil && !errors.Is(err, ErrInvalidRule) {
+				t.Errorf("Validate() error = %v, want %v", err, ErrInvalidRule)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package pgbouncerini
+
+import "io"
+
+// DatabasesQuery lists the databases PGBouncer may route clients to.
+const DatabasesQuery = "select datname from pg_database where datallowconn and not datistemplate order by datname asc"
+
+// UsersQuery returns every role with its comment, see ParseComment.
+const UsersQuery = "select rolname, shobj_description(oid, 'pg_authid') as comment from pg_roles order by rolname asc"
+
+// DatabasesSection renders a [databases] fragment pgbouncer.ini pulls in
+// with %include.
+type DatabasesSection interface {
+	// Add records a database, it returns false when the rules exclude it
+	Add(name string) (bool, error)
+	Write(w io.Writer) error
+}
+
+func NewDatabasesSection(rules *DatabaseRules) DatabasesSection {
+	if rules == nil {
+		rules = new(DatabaseRules)
+	}
+
+	return &Databases{
+		rules:   rules,
+		entries: map[string]string{},
+	}
+}
+
+// UsersSection renders a [users] fragment pgbouncer.ini pulls in with
+// %include.
+type UsersSection interface {
+	// Set merges settings into the ones of the user, set fields win
+	Set(name string, settings *UserSettings) error
+	Write(w io.Writer) error
+}
+
+func NewUsersSection() UsersSection {
+	return &Users{
+		settings: map[string]*UserSettings{},
+	}
+}
@@ -0,0 +1,381 @@
+package pgbouncerini
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// CommentPrefix starts the role comments holding PGBouncer settings,
+	// as in 'pgbouncer: pool_mode=transaction max_user_connections=50'
+	CommentPrefix = "pgbouncer:"
+
+	// Both pgbouncer.ini and the auth file skip lines starting with a semicolon
+	header = "; generated by pgbouncer-updater, do not edit\n"
+	entry  = "%s = %s\n"
+)
+
+var (
+	ErrInvalidName     = errors.New("name can't be used unquoted in pgbouncer.ini")
+	ErrInvalidHost     = errors.New("invalid database host or port")
+	ErrInvalidPoolMode = errors.New("pool_mode must be session, transaction or statement")
+	ErrInvalidSetting  = errors.New("invalid user setting")
+
+	// Names written as pgbouncer.ini keys and connection string values
+	plainName = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+	// Directories holding the server unix socket
+	socketDir = regexp.MustCompile(`^/[A-Za-z0-9_./-]*$`)
+)
+
+// DatabaseRules selects the databases of the source cluster and tells how
+// PGBouncer connects to them.
+type DatabaseRules struct {
+	// Server PGBouncer connects to, the configuration fills in the host and
+	// port of the credentials when unset
+	Host string `yaml:"host,omitempty"`
+	Port int64  `yaml:"port,omitempty"`
+	// Shell patterns matched against database names, every database when
+	// include is empty, exclude wins over include
+	Include []string `yaml:"include,omitempty"`
+	Exclude []string `yaml:"exclude,omitempty"`
+	// Settings by database name
+	Overrides map[string]*DatabaseOverride `yaml:"overrides,omitempty"`
+}
+
+type DatabaseOverride struct {
+	Host     string `yaml:"host,omitempty"`
+	Port     int64  `yaml:"port,omitempty"`
+	PoolSize *int   `yaml:"pool_size,omitempty"`
+	PoolMode string `yaml:"pool_mode,omitempty"`
+}
+
+// UserSettings are the per user pgbouncer.ini settings.
+type UserSettings struct {
+	PoolMode           string `yaml:"pool_mode,omitempty"`
+	PoolSize           *int   `yaml:"pool_size,omitempty"`
+	MaxUserConnections *int   `yaml:"max_user_connections,omitempty"`
+}
+
+type Databases struct {
+	rules   *DatabaseRules
+	entries map[string]string
+}
+
+func (d *Databases) Add(name string) (bool, error) {
+	if !d.rules.selects(name) {
+		return false, nil
+	}
+
+	if !plainName.MatchString(name) {
+		return false, fmt.Errorf("%w: database %q", ErrInvalidName, name)
+	}
+
+	override := d.rules.Overrides[name]
+	if override == nil {
+		override = new(DatabaseOverride)
+	}
+
+	params := []string{}
+	host := firstNonEmpty(override.Host, d.rules.Host)
+	if host != "" {
+		if err := validateHost(host); err != nil {
+			return false, fmt.Errorf("database %s: %w", name, err)
+		}
+		params = append(params, "host="+host)
+	}
+
+	port := override.Port
+	if port == 0 {
+		port = d.rules.Port
+	}
+	if port != 0 {
+		if err := validatePort(port); err != nil {
+			return false, fmt.Errorf("database %s: %w", name, err)
+		}
+		params = append(params, "port="+strconv.FormatInt(port, 10))
+	}
+
+	params = append(params, "dbname="+name)
+
+	if override.PoolSize != nil {
+		params = append(params, "pool_size="+strconv.Itoa(*override.PoolSize))
+	}
+
+	if override.PoolMode != "" {
+		if err := ValidatePoolMode(override.PoolMode); err != nil {
+			return false, fmt.Errorf("database %s: %w", name, err)
+		}
+		params = append(params, "pool_mode="+override.PoolMode)
+	}
+
+	d.entries[name] = strings.Join(params, " ")
+	return true, nil
+}
+
+// Write renders the section, databases sorted by name.
+func (d *Databases) Write(w io.Writer) error {
+	return writeSection(w, "databases", d.entries)
+}
+
+type Users struct {
+	settings map[string]*UserSettings
+}
+
+func (u *Users) Set(name string, settings *UserSettings) error {
+	if !plainName.MatchString(name) {
+		return fmt.Errorf("%w: user %q", ErrInvalidName, name)
+	}
+
+	if err := settings.Validate(); err != nil {
+		return fmt.Errorf("user %s: %w", name, err)
+	}
+
+	current, found := u.settings[name]
+	if !found {
+		current = new(UserSettings)
+		u.settings[name] = current
+	}
+	current.merge(settings)
+
+	return nil
+}
+
+// Write renders the section, users sorted by name, users without settings
+// are left out.
+func (u *Users) Write(w io.Writer) error {
+	entries := map[string]string{}
+	for name, settings := range u.settings {
+		if params := settings.params(); len(params) > 0 {
+			entries[name] = strings.Join(params, " ")
+		}
+	}
+
+	return writeSection(w, "users", entries)
+}
+
+// ParseComment reads the settings of a role comment, found is false when
+// the comment doesn't start with CommentPrefix.
+func ParseComment(comment string) (settings *UserSettings, found bool, err error) {
+	comment = strings.TrimSpace(comment)
+	if !strings.HasPrefix(comment, CommentPrefix) {
+		return nil, false, nil
+	}
+
+	settings = new(UserSettings)
+	fields := strings.FieldsFunc(comment[len(CommentPrefix):], func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+	for _, field := range fields {
+		pair := strings.SplitN(field, "=", 2)
+		if len(pair) != 2 {
+			return nil, true, fmt.Errorf("%w: %q is not key=value", ErrInvalidSetting, field)
+		}
+		key, value := pair[0], pair[1]
+
+		switch key {
+		case "pool_mode":
+			settings.PoolMode = value
+		case "pool_size":
+			settings.PoolSize, err = parseCount(key, value)
+		case "max_user_connections":
+			settings.MaxUserConnections, err = parseCount(key, value)
+		default:
+			err = fmt.Errorf("%w: unknown %s", ErrInvalidSetting, key)
+		}
+		if err != nil {
+			return nil, true, err
+		}
+	}
+
+	return settings, true, settings.Validate()
+}
+
+// Validate checks the pool mode and the counts.
+func (s *UserSettings) Validate() error {
+	if s.PoolMode != "" {
+		if err := ValidatePoolMode(s.PoolMode); err != nil {
+			return err
+		}
+	}
+
+	for key, count := range map[string]*int{"pool_size": s.PoolSize, "max_user_connections": s.MaxUserConnections} {
+		if count != nil && *count < 0 {
+			return fmt.Errorf("%w: negative %s", ErrInvalidSetting, key)
+		}
+	}
+
+	return nil
+}
+
+func (s *UserSettings) merge(in *UserSettings) {
+	if in.PoolMode != "" {
+		s.PoolMode = in.PoolMode
+	}
+
+	if in.PoolSize != nil {
+		s.PoolSize = in.PoolSize
+	}
+
+	if in.MaxUserConnections != nil {
+		s.MaxUserConnections = in.MaxUserConnections
+	}
+}
+
+func (s *UserSettings) params() []string {
+	params := []string{}
+	if s.PoolMode != "" {
+		params = append(params, "pool_mode="+s.PoolMode)
+	}
+
+	if s.PoolSize != nil {
+		params = append(params, "pool_size="+strconv.Itoa(*s.PoolSize))
+	}
+
+	if s.MaxUserConnections != nil {
+		params = append(params, "max_user_connections="+strconv.Itoa(*s.MaxUserConnections))
+	}
+
+	return params
+}
+
+func parseCount(key, value string) (*int, error) {
+	count, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s=%q is not a number", ErrInvalidSetting, key, value)
+	}
+
+	return &count, nil
+}
+
+// ValidatePoolMode checks a pool_mode value.
+func ValidatePoolMode(mode string) error {
+	switch mode {
+	case "session", "transaction", "statement":
+		return nil
+	}
+
+	return fmt.Errorf("%w, not %q", ErrInvalidPoolMode, mode)
+}
+
+// Validate checks the patterns, the server address and the overrides.
+func (r *DatabaseRules) Validate() error {
+	for _, pattern := range append(append([]string{}, r.Include...), r.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("pattern %q: %w", pattern, err)
+		}
+	}
+
+	if err := validateAddress(r.Host, r.Port); err != nil {
+		return err
+	}
+
+	for name, override := range r.Overrides {
+		if override == nil {
+			continue
+		}
+
+		if err := validateAddress(override.Host, override.Port); err != nil {
+			return fmt.Errorf("database %s: %w", name, err)
+		}
+
+		if override.PoolMode != "" {
+			if err := ValidatePoolMode(override.PoolMode); err != nil {
+				return fmt.Errorf("database %s: %w", name, err)
+			}
+		}
+
+		if override.PoolSize != nil && *override.PoolSize < 0 {
+			return fmt.Errorf("database %s: %w: negative pool_size", name, ErrInvalidSetting)
+		}
+	}
+
+	return nil
+}
+
+// validateAddress checks a host and port, both optional.
+func validateAddress(host string, port int64) error {
+	if host != "" {
+		if err := validateHost(host); err != nil {
+			return err
+		}
+	}
+
+	if port != 0 {
+		return validatePort(port)
+	}
+
+	return nil
+}
+
+// validateHost accepts a host name, an IP address or a socket directory,
+// the port goes in its own setting.
+func validateHost(host string) error {
+	if plainName.MatchString(host) || socketDir.MatchString(host) || net.ParseIP(host) != nil {
+		return nil
+	}
+
+	return fmt.Errorf("%w: host %q is not a host name, an IP address or a socket directory", ErrInvalidHost, host)
+}
+
+func validatePort(port int64) error {
+	if port < 1 || port > 65535 {
+		return fmt.Errorf("%w: port %d out of range", ErrInvalidHost, port)
+	}
+
+	return nil
+}
+
+func (r *DatabaseRules) selects(name string) bool {
+	for _, pattern := range r.Exclude {
+		if matched, _ := path.Match(pattern, name); matched {
+			return false
+		}
+	}
+
+	if len(r.Include) == 0 {
+		return true
+	}
+
+	for _, pattern := range r.Include {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+func writeSection(w io.Writer, section string, entries map[string]string) error {
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buf := bufio.NewWriter(w)
+	buf.WriteString(header)
+	fmt.Fprintf(buf, "[%s]\n", section)
+	for _, name := range names {
+		fmt.Fprintf(buf, entry, name, entries[name])
+	}
+
+	return buf.Flush()
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,219 @@
+package pgbouncerini
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDatabases(t *testing.T) {
+	poolSize := 20
+
+	tests := []struct {
+		name      string
+		rules     *DatabaseRules
+		databases []string
+		want      string
+		wantErr   error
+	}{
+		{
+			name:      "No rules",
+			databases: []string{"billing", "app"},
+			want:      "; generated by pgbouncer-updater, do not edit\n[databases]\napp = dbname=app\nbilling = dbname=billing\n",
+		},
+		{
+			name: "Include exclude",
+			rules: &DatabaseRules{
+				Host:    "10.0.0.1",
+				Port:    5432,
+				Include: []string{"app*", "billing"},
+				Exclude: []string{"app_test"},
+			},
+			databases: []string{"app", "app_test", "billing", "postgres"},
+			want:      "; generated by pgbouncer-updater, do not edit\n[databases]\napp = host=10.0.0.1 port=5432 dbname=app\nbilling = host=10.0.0.1 port=5432 dbname=billing\n",
+		},
+		{
+			name: "Overrides",
+			rules: &DatabaseRules{
+				Host: "10.0.0.1",
+				Overrides: map[string]*DatabaseOverride{
+					"app": {Host: "replica", Port: 6432, PoolSize: &poolSize, PoolMode: "transaction"},
+				},
+			},
+			databases: []string{"app", "billing"},
+			want:      "; generated by pgbouncer-updater, do not edit\n[databases]\napp = host=replica port=6432 dbname=app pool_size=20 pool_mode=transaction\nbilling = host=10.0.0.1 dbname=billing\n",
+		},
+		{
+			name: "Socket directory and IPv6",
+			rules: &DatabaseRules{
+				Host: "/var/run/postgresql",
+				Overrides: map[string]*DatabaseOverride{
+					"app": {Host: "fd00::1"},
+				},
+			},
+			databases: []string{"app", "billing"},
+			want:      "; generated by pgbouncer-updater, do not edit\n[databases]\napp = host=fd00::1 dbname=app\nbilling = host=/var/run/postgresql dbname=billing\n",
+		},
+		{
+			name:      "Invalid name",
+			databases: []string{"my db"},
+			wantErr:   ErrInvalidName,
+		},
+		{
+			name:      "Host with port",
+			rules:     &DatabaseRules{Host: "db:5432"},
+			databases: []string{"app"},
+			wantErr:   ErrInvalidHost,
+		},
+		{
+			name: "Invalid pool mode",
+			rules: &DatabaseRules{
+				Overrides: map[string]*DatabaseOverride{"app": {PoolMode: "txn"}},
+			},
+			databases: []string{"app"},
+			wantErr:   ErrInvalidPoolMode,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			section := NewDatabasesSection(tt.rules)
+			for _, name := range tt.databases {
+				if _, err := section.Add(name); err != nil {
+					if !errors.Is(err, tt.wantErr) {
+						t.Errorf("Databases.Add() error = %v, want %v", err, tt.wantErr)
+					}
+					return
+				}
+			}
+			if tt.wantErr != nil {
+				t.Errorf("Databases.Add() error = nil, want %v", tt.wantErr)
+				return
+			}
+
+			w := new(bytes.Buffer)
+			if err := section.Write(w); err != nil {
+				t.Errorf("Databases.Write() error = %v", err)
+				return
+			}
+			if got := w.String(); got != tt.want {
+				t.Errorf("Databases.Write() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDatabaseRules_Validate(t *testing.T) {
+	zero := 0
+	negative := -1
+
+	tests := []struct {
+		name    string
+		rules   *DatabaseRules
+		wantErr bool
+	}{
+		{name: "Empty", rules: &DatabaseRules{}},
+		{name: "Patterns", rules: &DatabaseRules{Include: []string{"app_*"}, Exclude: []string{"*_test"}}},
+		{name: "Bad pattern", rules: &DatabaseRules{Exclude: []string{"[app"}}, wantErr: true},
+		{name: "Bad pool mode", rules: &DatabaseRules{Overrides: map[string]*DatabaseOverride{"app": {PoolMode: "txn"}}}, wantErr: true},
+		{name: "Host name", rules: &DatabaseRules{Host: "db-01.example.com", Port: 5432}},
+		{name: "IPv6", rules: &DatabaseRules{Host: "fd00::1"}},
+		{name: "Socket directory", rules: &DatabaseRules{Host: "/var/run/postgresql"}},
+		{name: "Host with port", rules: &DatabaseRules{Host: "db:5432"}, wantErr: true},
+		{name: "Host with space", rules: &DatabaseRules{Host: "db 01"}, wantErr: true},
+		{name: "Port out of range", rules: &DatabaseRules{Port: 70000}, wantErr: true},
+		{name: "Pool size", rules: &DatabaseRules{Overrides: map[string]*DatabaseOverride{"app": {PoolSize: &zero}}}},
+		{name: "Negative pool size", rules: &DatabaseRules{Overrides: map[string]*DatabaseOverride{"app": {PoolSize: &negative}}}, wantErr: true},
+		{name: "Bad override host", rules: &DatabaseRules{Overrides: map[string]*DatabaseOverride{"app": {Host: "[fd00::1]:5432"}}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.rules.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("DatabaseRules.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseComment(t *testing.T) {
+	fifty := 50
+	five := 5
+
+	tests := []struct {
+		name      string
+		comment   string
+		want      *UserSettings
+		wantFound bool
+		wantErr   bool
+	}{
+		{name: "Empty", comment: ""},
+		{name: "Other comment", comment: "application role"},
+		{
+			name:      "Pool mode",
+			comment:   "pgbouncer: pool_mode=transaction",
+			want:      &UserSettings{PoolMode: "transaction"},
+			wantFound: true,
+		},
+		{
+			name:      "Every setting",
+			comment:   "  pgbouncer:pool_mode=session, pool_size=5 max_user_connections=50",
+			want:      &UserSettings{PoolMode: "session", PoolSize: &five, MaxUserConnections: &fifty},
+			wantFound: true,
+		},
+		{name: "Unknown key", comment: "pgbouncer: reserve=1", wantFound: true, wantErr: true},
+		{name: "Not a number", comment: "pgbouncer: pool_size=many", wantFound: true, wantErr: true},
+		{name: "Negative", comment: "pgbouncer: pool_size=-1", wantFound: true, wantErr: true},
+		{name: "Bad pool mode", comment: "pgbouncer: pool_mode=txn", wantFound: true, wantErr: true},
+		{name: "No value", comment: "pgbouncer: pool_mode", wantFound: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found, err := ParseComment(tt.comment)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseComment() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if found != tt.wantFound {
+				t.Errorf("ParseComment() found = %v, want %v", found, tt.wantFound)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseComment() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUsers(t *testing.T) {
+	ten := 10
+	fifty := 50
+
+	section := NewUsersSection()
+	for _, set := range []struct {
+		name     string
+		settings *UserSettings
+	}{
+		{name: "report", settings: &UserSettings{PoolMode: "session", MaxUserConnections: &ten}},
+		{name: "app", settings: &UserSettings{PoolMode: "session"}},
+		{name: "app", settings: &UserSettings{PoolMode: "transaction", MaxUserConnections: &fifty}},
+		{name: "empty", settings: &UserSettings{}},
+	} {
+		if err := section.Set(set.name, set.settings); err != nil {
+			t.Errorf("Users.Set() error = %v", err)
+			return
+		}
+	}
+
+	if err := section.Set("bad user", &UserSettings{}); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("Users.Set() error = %v, want %v", err, ErrInvalidName)
+	}
+
+	w := new(bytes.Buffer)
+	if err := section.Write(w); err != nil {
+		t.Errorf("Users.Write() error = %v", err)
+		return
+	}
+	want := "; generated by pgbouncer-updater, do not edit\n[users]\napp = pool_mode=transaction max_user_connections=50\nreport = pool_mode=session max_user_connections=10\n"
+	if got := w.String(); got != want {
+		t.Errorf("Users.Write() = %q, want %q", got, want)
+	}
+}
@@ -2,21 +2,56 @@ package sendfile
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"net"
+	"os"
+	"time"
+)
+
+const (
+	TransportSCP  = "scp"
+	TransportSFTP = "sftp"
+)
+
+var (
+	ErrVerify = errors.New("remote file differs from the copied one")
 )
 
 type Scp interface {
 	Copy(context context.Context, sourceFile, destinationPath string) error
 	CompareFiles(currentFile, oldFile io.Reader) error
 	SaveOld(ctx context.Context, filePath, remotePath string) error
+	// WithPermissions sets the owner, group and mode of copied files
+	WithPermissions(perms *Permissions) Scp
+	// WithVerify reads back uploads before they replace the remote file,
+	// Copy then fails with ErrVerify when they differ
+	WithVerify(verify bool) Scp
+	// Stat describes a remote file, the error wraps os.ErrNotExist when it
+	// is missing
+	Stat(ctx context.Context, remotePath string) (*RemoteFile, error)
+	// RemoteChecksum returns the hex sha256 of a remote file, computed on the
+	// host unless sha256sum is missing there
+	RemoteChecksum(ctx context.Context, remotePath string) (string, error)
+	// Run executes a shell script on the host as the SSH user
+	Run(ctx context.Context, script string) error
+	// Dial and DialTimeout open connections from the host over its SSH
+	// connection, a client is a lib/pq Dialer
+	Dial(network, address string) (net.Conn, error)
+	DialTimeout(network, address string, timeout time.Duration) (net.Conn, error)
 	Close()
 }
 
-func NewScpClient(host, username string, port int64, privKey []byte, sudo bool) Scp {
+// NewScpClient returns an SCP client to host, dialed through the jump
+// hosts in order.
+func NewScpClient(host, username string, port int64, privKey []byte, sudo bool, jumps ...*Hop) Scp {
 	return &Host{
 		Username: username,
 		Host:     host,
 		PrivKey:  privKey,
+		sudo:     sudo,
+		jumps:    jumps,
 		remoteBinary: func(sudo bool) string {
 			if sudo {
 				return "sudo /usr/bin/scp"
@@ -25,3 +60,55 @@ func NewScpClient(host, username string, port int64, privKey []byte, sudo bool)
 		}(sudo),
 	}
 }
+
+func NewSftpClient(host, username string, port int64, privKey []byte, sudo bool, jumps ...*Hop) Scp {
+	return &SftpHost{
+		Host: NewScpClient(host, username, port, privKey, sudo, jumps...).(*Host),
+	}
+}
+
+// NewClient returns the client of transport, scp when empty.
+func NewClient(transport, host, username string, port int64, privKey []byte, sudo bool, jumps ...*Hop) (Scp, error) {
+	switch transport {
+	case "", TransportSCP:
+		return NewScpClient(host, username, port, privKey, sudo, jumps...), nil
+	case TransportSFTP:
+		return NewSftpClient(host, username, port, privKey, sudo, jumps...), nil
+	}
+
+	return nil, fmt.Errorf("unknown transport %q, use %s or %s", transport, TransportSCP, TransportSFTP)
+}
+
+// Verify reads back the size and checksum of a remote file and compares
+// them with the local one, the error wraps ErrVerify when they differ.
+func Verify(ctx context.Context, scp Scp, localPath, remotePath string) error {
+	local, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+
+	localSum, err := LocalChecksum(localPath)
+	if err != nil {
+		return err
+	}
+
+	remote, err := scp.Stat(ctx, remotePath)
+	if err != nil {
+		return err
+	}
+
+	if remote.Size != local.Size() {
+		return fmt.Errorf("%w: %s is %d bytes, sent %d", ErrVerify, remotePath, remote.Size, local.Size())
+	}
+
+	remoteSum, err := scp.RemoteChecksum(ctx, remotePath)
+	if err != nil {
+		return err
+	}
+
+	if remoteSum != localSum {
+		return fmt.Errorf("%w: %s sha256 is %s, sent %s", ErrVerify, remotePath, remoteSum, localSum)
+	}
+
+	return nil
+}
@@ -0,0 +1,196 @@
+package sendfile
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// sftpServerScript starts the SFTP server through sudo wherever the
+// distribution puts it, the sftp subsystem can't run through sudo.
+const sftpServerScript = `for server in /usr/lib/openssh/sftp-server /usr/libexec/openssh/sftp-server /usr/lib/ssh/sftp-server /usr/libexec/sftp-server; do
+	[ -x "$server" ] && exec sudo "$server"
+done
+echo "sftp-server not found" >&2
+exit 127`
+
+// SftpHost transfers files over SFTP, remote commands still go through
+// SSH exec sessions of the embedded Host.
+type SftpHost struct {
+	*Host
+	client *sftp.Client
+}
+
+func (s *SftpHost) WithPermissions(perms *Permissions) Scp {
+	s.perms = perms
+	return s
+}
+
+func (s *SftpHost) WithVerify(verify bool) Scp {
+	s.verify = verify
+	return s
+}
+
+func (s *SftpHost) SaveOld(ctx context.Context, filePath, remotePath string) error {
+	f, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := s.startClient(); err != nil {
+		return err
+	}
+
+	return s.withContext(ctx, func(client *sftp.Client) error {
+		r, err := client.Open(remotePath)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		_, err = io.Copy(f, r)
+		return err
+	})
+}
+
+func (s *SftpHost) Copy(ctx context.Context, sourceFile, destinationFile string) error {
+	return s.atomicCopy(ctx, s, sourceFile, destinationFile, s.upload)
+}
+
+func (s *SftpHost) RemoteChecksum(ctx context.Context, remotePath string) (string, error) {
+	return s.checksum(ctx, remotePath, func(ctx context.Context, w io.Writer) error {
+		if err := s.startClient(); err != nil {
+			return err
+		}
+
+		return s.withContext(ctx, func(client *sftp.Client) error {
+			r, err := client.Open(remotePath)
+			if err != nil {
+				return err
+			}
+			defer r.Close()
+
+			_, err = io.Copy(w, r)
+			return err
+		})
+	})
+}
+
+func (s *SftpHost) Close() {
+	if s.client != nil {
+		s.client.Close()
+		s.client = nil
+	}
+
+	s.Host.Close()
+}
+
+func (s *SftpHost) upload(ctx context.Context, dstPath string) error {
+	if err := s.startClient(); err != nil {
+		return err
+	}
+
+	return s.withContext(ctx, func(client *sftp.Client) error {
+		f, err := client.OpenFile(dstPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+		if err != nil {
+			return err
+		}
+
+		// Restrict the file before writing, the server umask applies on create
+		if err := f.Chmod(s.file.mode); err != nil {
+			f.Close()
+			return err
+		}
+
+		if _, err := io.Copy(f, s.file.content); err != nil {
+			f.Close()
+			return err
+		}
+
+		return f.Close()
+	})
+}
+
+// startClient starts the SFTP client once, as root through sudo when
+// asked.
+func (s *SftpHost) startClient() error {
+	if s.client != nil {
+		return nil
+	}
+
+	if err := s.connect(); err != nil {
+		return err
+	}
+
+	if !s.sudo {
+		client, err := sftp.NewClient(s.conn)
+		if err != nil {
+			return err
+		}
+		s.client = client
+		return nil
+	}
+
+	session, err := s.conn.NewSession()
+	if err != nil {
+		return err
+	}
+
+	w, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	r, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := session.Start("sh -c " + ShellQuote(sftpServerScript)); err != nil {
+		return err
+	}
+
+	client, err := sftp.NewClientPipe(r, w)
+	if err != nil {
+		session.Close()
+		return err
+	}
+	s.client = client
+
+	return nil
+}
+
+// withContext runs fn and closes the client to stop it when ctx is done
+// first, the next operation starts a new client.
+func (s *SftpHost) withContext(ctx context.Context, fn func(client *sftp.Client) error) error {
+	client := s.client
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- fn(client)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+
+	case <-ctx.Done():
+		client.Close()
+		s.client = nil
+		// Let fn return before its file is reused
+		select {
+		case <-errCh:
+		case <-time.After(cleanupTimeout):
+		}
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,179 @@
+package sendfile
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Hop is an SSH jump host, connections are tunnelled through hops in order.
+type Hop struct {
+	// host:port
+	Addr     string
+	Username string
+	PrivKey  []byte
+}
+
+// Tunnel dials addresses from the last of its hops. It is a lib/pq Dialer,
+// database connections can go through the jump hosts.
+type Tunnel struct {
+	hops    []*Hop
+	mu      sync.Mutex
+	clients []*ssh.Client
+}
+
+func NewTunnel(hops ...*Hop) *Tunnel {
+	return &Tunnel{
+		hops: hops,
+	}
+}
+
+func (t *Tunnel) Dial(network, address string) (net.Conn, error) {
+	return t.DialTimeout(network, address, 0)
+}
+
+// DialTimeout connects the hops on first use and opens a forwarded
+// connection to address from the last one.
+func (t *Tunnel) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	return dialTimeout(address, timeout, func() (net.Conn, error) {
+		return t.dial(network, address)
+	})
+}
+
+// dialTimeout gives up on dial after timeout, none when zero.
+func dialTimeout(address string, timeout time.Duration, dial func() (net.Conn, error)) (net.Conn, error) {
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+
+	resCh := make(chan result, 1)
+	go func() {
+		conn, err := dial()
+		resCh <- result{conn, err}
+	}()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case res := <-resCh:
+		return res.conn, res.err
+	case <-expired:
+		// Close the connection if it is established afterwards
+		go func() {
+			if res := <-resCh; res.conn != nil {
+				res.conn.Close()
+			}
+		}()
+		return nil, fmt.Errorf("dial %s over SSH: timeout after %s", address, timeout)
+	}
+}
+
+func (t *Tunnel) dial(network, address string) (net.Conn, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.clients == nil {
+		clients, err := dialHops(t.hops)
+		if err != nil {
+			return nil, err
+		}
+		t.clients = clients
+	}
+
+	conn, err := t.clients[len(t.clients)-1].Dial(network, address)
+	if err != nil {
+		// The chain may be broken, redial it next time
+		closeClients(t.clients)
+		t.clients = nil
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+func (t *Tunnel) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	closeClients(t.clients)
+	t.clients = nil
+	return nil
+}
+
+// dialHops connects every hop through the previous ones.
+func dialHops(hops []*Hop) ([]*ssh.Client, error) {
+	if len(hops) == 0 {
+		return nil, fmt.Errorf("no jump host")
+	}
+
+	clients := []*ssh.Client{}
+	for _, hop := range hops {
+		config, err := clientConfig(hop.Username, hop.PrivKey)
+		if err != nil {
+			closeClients(clients)
+			return nil, fmt.Errorf("jump host %s: %w", hop.Addr, err)
+		}
+
+		client, err := dialThrough(clients, hop.Addr, config)
+		if err != nil {
+			closeClients(clients)
+			return nil, fmt.Errorf("jump host %s: %w", hop.Addr, err)
+		}
+		clients = append(clients, client)
+	}
+
+	return clients, nil
+}
+
+// dialThrough opens an SSH connection to addr from the last of clients,
+// directly when there is none.
+func dialThrough(clients []*ssh.Client, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	if len(clients) == 0 {
+		return ssh.Dial("tcp", addr, config)
+	}
+
+	conn, err := clients[len(clients)-1].Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	c, channels, requests, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return ssh.NewClient(c, channels, requests), nil
+}
+
+// closeClients closes a chain from its last hop.
+func closeClients(clients []*ssh.Client) {
+	for i := len(clients) - 1; i >= 0; i-- {
+		clients[i].Close()
+	}
+}
+
+func clientConfig(username string, privKey []byte) (*ssh.ClientConfig, error) {
+	signer, err := ssh.ParsePrivateKey(privKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ssh.ClientConfig{
+		User:            username,
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+
+		Auth: []ssh.AuthMethod{
+			ssh.PublicKeys(signer),
+		},
+	}, nil
+}
@@ -3,11 +3,15 @@ package sendfile
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -15,19 +19,49 @@ import (
 	"golang.org/x/crypto/ssh"
 )
 
+const (
+	cleanupTimeout    = 10 * time.Second
+	keepAliveInterval = 30 * time.Second
+	keepAliveTimeout  = 15 * time.Second
+	// Shell exit status of a missing command
+	commandNotFound = 127
+)
+
 var (
 	ErrorDiff = fmt.Errorf("Content are not same")
 )
 
 type Host struct {
 	conn         *ssh.Client
-	session      *ssh.Session
+	done         chan struct{}
 	Host         string
 	Username     string
 	PrivKey      []byte
 	file         *File
 	remoteBinary string
+	sudo         bool
+	perms        *Permissions
+	verify       bool
 	timeout      time.Duration
+	// Jump hosts chain, the host is dialed from the last one
+	jumps       []*Hop
+	jumpClients []*ssh.Client
+}
+
+// Permissions are applied to copied files, unset fields keep the ones of
+// the replaced file, a new one is 0640.
+type Permissions struct {
+	Owner string
+	Group string
+	Mode  os.FileMode
+}
+
+// RemoteFile is a remote file as reported by stat.
+type RemoteFile struct {
+	Owner string
+	Group string
+	Mode  os.FileMode
+	Size  int64
 }
 
 type File struct {
@@ -60,6 +94,7 @@ func (h *Host) SaveOld(ctx context.Context, filePath, remotePath string) error {
 	if err != nil {
 		return err
 	}
+	defer f.Close()
 
 	if err := h.connect(); err != nil {
 		return err
@@ -69,6 +104,15 @@ func (h *Host) SaveOld(ctx context.Context, filePath, remotePath string) error {
 }
 
 func (h *Host) Copy(ctx context.Context, sourceFile, destinationFile string) error {
+	return h.atomicCopy(ctx, h, sourceFile, destinationFile, h.copy)
+}
+
+// atomicCopy uploads sourceFile next to the destination then renames it
+// over, PGBouncer never reads a partial file and a failed upload leaves the
+// old one. The upload is only readable by its owner until the permissions
+// of the destination or the configured ones are applied. With verify, remote
+// reads the upload back first and a corrupt one is removed.
+func (h *Host) atomicCopy(ctx context.Context, remote Scp, sourceFile, destinationFile string, upload func(ctx context.Context, dstPath string) error) error {
 	var err error
 
 	file, err := os.Open(sourceFile)
@@ -86,9 +130,10 @@ func (h *Host) Copy(ctx context.Context, sourceFile, destinationFile string) err
 		return fmt.Errorf("empty file")
 	}
 
+	tmpFile := tempPath(destinationFile)
 	h.file = &File{
-		fileName: filepath.Base(destinationFile),
-		mode:     s.Mode().Perm(),
+		fileName: filepath.Base(tmpFile),
+		mode:     tempMode,
 		size:     s.Size(),
 		content:  file,
 	}
@@ -97,44 +142,256 @@ func (h *Host) Copy(ctx context.Context, sourceFile, destinationFile string) err
 		return err
 	}
 
-	return h.copy(ctx, destinationFile)
+	if err := upload(ctx, tmpFile); err != nil {
+		h.remove(tmpFile)
+		return err
+	}
+
+	if h.verify {
+		if err := Verify(ctx, remote, sourceFile, tmpFile); err != nil {
+			h.remove(tmpFile)
+			return err
+		}
+	}
+
+	if err := h.Run(ctx, replaceScript(tmpFile, destinationFile, h.perms, h.sudo)); err != nil {
+		h.remove(tmpFile)
+		return err
+	}
+
+	return nil
 }
-func (h *Host) auth() (*ssh.ClientConfig, error) {
-	signer, err := ssh.ParsePrivateKey(h.PrivKey)
+
+func (h *Host) WithPermissions(perms *Permissions) Scp {
+	h.perms = perms
+	return h
+}
+
+func (h *Host) WithVerify(verify bool) Scp {
+	h.verify = verify
+	return h
+}
+
+func (h *Host) Stat(ctx context.Context, remotePath string) (*RemoteFile, error) {
+	if err := h.connect(); err != nil {
+		return nil, err
+	}
+
+	out, err := h.output(ctx, h.asRoot("stat -c '%U %G %a %s' -- "+ShellQuote(remotePath)))
+	if err != nil {
+		return nil, remoteError(err.Error())
+	}
+
+	return parseStat(out)
+}
+
+func (h *Host) RemoteChecksum(ctx context.Context, remotePath string) (string, error) {
+	return h.checksum(ctx, remotePath, func(ctx context.Context, w io.Writer) error {
+		if err := h.connect(); err != nil {
+			return err
+		}
+
+		return h.copyFrom(ctx, w, remotePath)
+	})
+}
+
+// checksum runs sha256sum on the host, or hashes what download writes when
+// the binary is missing.
+func (h *Host) checksum(ctx context.Context, remotePath string, download func(ctx context.Context, w io.Writer) error) (string, error) {
+	if err := h.connect(); err != nil {
+		return "", err
+	}
+
+	// sudo fails with its own status when the command is missing
+	out, err := h.output(ctx, "command -v sha256sum >/dev/null || exit 127; "+h.asRoot("sha256sum -- "+ShellQuote(remotePath)))
+	if err == nil {
+		return parseChecksum(out)
+	}
+
+	var exitErr *ssh.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitStatus() != commandNotFound {
+		return "", remoteError(err.Error())
+	}
+
+	log.Debug("No sha256sum on ", h.Host, ", download ", remotePath)
+	hash := sha256.New()
+	if err := download(ctx, hash); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Run executes a shell script on the host as the SSH user, commands that
+// need root go through sudo themselves.
+func (h *Host) Run(ctx context.Context, script string) error {
+	_, err := h.output(ctx, script)
+	return err
+}
+
+// output executes a shell script on the host and returns its output.
+func (h *Host) output(ctx context.Context, script string) (string, error) {
+	session, err := h.newSession()
 	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	session.Stdout = stdout
+	session.Stderr = stderr
+
+	command := "sh -c " + ShellQuote(script)
+
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- session.Run(command)
+	}()
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			return "", fmt.Errorf("%s: %w: %s", script, err, strings.TrimSpace(stderr.String()))
+		}
+		return stdout.String(), nil
+
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// remove deletes a leftover temporary file, even when the copy context is
+// done.
+func (h *Host) Dial(network, address string) (net.Conn, error) {
+	return h.DialTimeout(network, address, 0)
+}
+
+// DialTimeout opens a forwarded connection to address from the host, over
+// the SSH connection copies use.
+func (h *Host) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	if err := h.connect(); err != nil {
 		return nil, err
 	}
 
-	return &ssh.ClientConfig{
-		User:            h.Username,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	conn := h.conn
+	return dialTimeout(address, timeout, func() (net.Conn, error) {
+		return conn.Dial(network, address)
+	})
+}
+
+// asRoot prefixes command with sudo when the host is used as a sudoer,
+// only fixed commands are run this way and no root shell is needed.
+func (h *Host) asRoot(command string) string {
+	if h.sudo {
+		return "sudo " + command
+	}
+
+	return command
+}
+
+func (h *Host) remove(path string) {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
 
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(signer),
-		},
-	}, nil
+	if err := h.Run(ctx, h.asRoot("rm -f -- "+ShellQuote(path))); err != nil {
+		log.Warn("Failed to remove ", path, ": ", err)
+	}
+}
+func (h *Host) auth() (*ssh.ClientConfig, error) {
+	return clientConfig(h.Username, h.PrivKey)
 }
 
+// connect dials the host once, through its jump hosts if any, every
+// operation then opens its own session.
 func (h *Host) connect() error {
+	if h.conn != nil {
+		return nil
+	}
+
 	clientConfig, err := h.auth()
 	if err != nil {
 		return err
 	}
 
-	h.conn, err = ssh.Dial("tcp", h.Host, clientConfig)
-	if err != nil {
-		return err
+	if len(h.jumps) > 0 {
+		h.jumpClients, err = dialHops(h.jumps)
+		if err != nil {
+			return err
+		}
 	}
 
-	h.session, err = h.conn.NewSession()
+	h.conn, err = dialThrough(h.jumpClients, h.Host, clientConfig)
 	if err != nil {
+		closeClients(h.jumpClients)
+		h.jumpClients = nil
 		return err
 	}
 
+	// Closed by Close to stop the keepalives
+	h.done = make(chan struct{})
+	go h.keepAlive(h.conn, h.done)
+
 	return nil
 }
 
+func (h *Host) newSession() (*ssh.Session, error) {
+	if err := h.connect(); err != nil {
+		return nil, err
+	}
+
+	return h.conn.NewSession()
+}
+
+// keepAlive closes conn when the peer stops answering, pending operations
+// then fail instead of hanging.
+func (h *Host) keepAlive(conn *ssh.Client, done chan struct{}) {
+	ticker := time.NewTicker(keepAliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		replied := make(chan error, 1)
+		go func() {
+			// Any reply, even a refusal, proves the peer is alive
+			_, _, err := conn.SendRequest("keepalive@openssh.com", true, nil)
+			replied <- err
+		}()
+
+		select {
+		case <-done:
+			return
+		case err := <-replied:
+			if err != nil {
+				log.Warn("SSH keepalive to ", h.Host, " failed: ", err)
+				conn.Close()
+				return
+			}
+		case <-time.After(keepAliveTimeout):
+			log.Warn("SSH peer ", h.Host, " stopped answering keepalives")
+			conn.Close()
+			return
+		}
+	}
+}
+
 func (h *Host) copyFrom(ctx context.Context, w io.Writer, remotePath string) error {
+	session, err := h.newSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
 	wg := sync.WaitGroup{}
 	errCh := make(chan error, 4)
 
@@ -150,14 +407,14 @@ func (h *Host) copyFrom(ctx context.Context, w io.Writer, remotePath string) err
 
 		}()
 
-		r, err := h.session.StdoutPipe()
+		r, err := session.StdoutPipe()
 		if err != nil {
 			log.Error(err)
 			errCh <- err
 			return
 		}
 
-		in, err := h.session.StdinPipe()
+		in, err := session.StdinPipe()
 		if err != nil {
 			log.Error(err)
 			errCh <- err
@@ -165,7 +422,7 @@ func (h *Host) copyFrom(ctx context.Context, w io.Writer, remotePath string) err
 		}
 		defer in.Close()
 
-		err = h.session.Start(fmt.Sprintf("%s -f %q", h.remoteBinary, remotePath))
+		err = session.Start(fmt.Sprintf("%s -f %q", h.remoteBinary, remotePath))
 		if err != nil {
 			log.Error(err)
 			errCh <- err
@@ -187,7 +444,7 @@ func (h *Host) copyFrom(ctx context.Context, w io.Writer, remotePath string) err
 		}
 		if res.IsFailure() {
 			log.Error(errors.New(res.GetMessage()))
-			errCh <- errors.New(res.GetMessage())
+			errCh <- remoteError(res.GetMessage())
 			return
 		}
 
@@ -219,7 +476,7 @@ func (h *Host) copyFrom(ctx context.Context, w io.Writer, remotePath string) err
 			return
 		}
 
-		err = h.session.Wait()
+		err = session.Wait()
 		if err != nil {
 			log.Error(err)
 			errCh <- err
@@ -242,12 +499,18 @@ func (h *Host) copyFrom(ctx context.Context, w io.Writer, remotePath string) err
 
 }
 func (h *Host) copy(ctx context.Context, dstPath string) error {
-	stdout, err := h.session.StdoutPipe()
+	session, err := h.newSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	stdout, err := session.StdoutPipe()
 	if err != nil {
 		return err
 	}
 
-	w, err := h.session.StdinPipe()
+	w, err := session.StdinPipe()
 	if err != nil {
 		return err
 	}
@@ -293,7 +556,7 @@ func (h *Host) copy(ctx context.Context, dstPath string) error {
 
 	go func() {
 		defer wg.Done()
-		err := h.session.Run(fmt.Sprintf("%s -qt %q", h.remoteBinary, dstPath))
+		err := session.Run(fmt.Sprintf("%s -qt %q", h.remoteBinary, dstPath))
 		if err != nil {
 			errCh <- err
 			return
@@ -320,13 +583,18 @@ func (h *Host) copy(ctx context.Context, dstPath string) error {
 }
 
 func (h *Host) Close() {
-	if h.conn.Close() != nil {
-		h.conn.Close()
+	if h.done != nil {
+		close(h.done)
+		h.done = nil
 	}
 
-	if h.session != nil {
-		h.session.Close()
+	if h.conn != nil {
+		h.conn.Close()
+		h.conn = nil
 	}
+
+	closeClients(h.jumpClients)
+	h.jumpClients = nil
 }
 
 func wait(wg *sync.WaitGroup, ctx context.Context) error {
@@ -345,6 +613,15 @@ func wait(wg *sync.WaitGroup, ctx context.Context) error {
 	}
 }
 
+// remoteError wraps os.ErrNotExist when the remote file is missing.
+func remoteError(message string) error {
+	if strings.Contains(message, "No such file or directory") {
+		return fmt.Errorf("%w: %s", os.ErrNotExist, strings.TrimSpace(message))
+	}
+
+	return errors.New(message)
+}
+
 func checkResponse(r io.Reader) error {
 	response, err := ParseResponse(r)
 	if err != nil {
@@ -2,18 +2,32 @@ package sendfile
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
 	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
 )
 
 func TestHost_Copy(t *testing.T) {
 	type fields struct {
 		conn     *ssh.Client
-		session  *ssh.Session
 		Host     string
 		Username string
 		PrivKey  []byte
@@ -74,7 +88,6 @@ kezKtxKihlsqKKDJAAAAEGpvYmRAUkQtTElOVVgtMDEBAg==
 		t.Run(tt.name, func(t *testing.T) {
 			h := &Host{
 				conn:         tt.fields.conn,
-				session:      tt.fields.session,
 				Host:         tt.fields.Host,
 				Username:     tt.fields.Username,
 				PrivKey:      tt.fields.PrivKey,
@@ -126,3 +139,898 @@ func TestHost_CompareFiles(t *testing.T) {
 		})
 	}
 }
+
+func Test_remoteError(t *testing.T) {
+	tests := []struct {
+		message  string
+		notExist bool
+	}{
+		{message: "scp: /etc/pgbouncer/databases.ini: No such file or directory\n", notExist: true},
+		{message: "scp: /etc/pgbouncer/userlist.txt: Permission denied\n", notExist: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.message, func(t *testing.T) {
+			if got := errors.Is(remoteError(tt.message), os.ErrNotExist); got != tt.notExist {
+				t.Errorf("remoteError() is os.ErrNotExist = %v, want %v", got, tt.notExist)
+			}
+		})
+	}
+}
+
+func Test_tempPath(t *testing.T) {
+	got := tempPath("/etc/pgbouncer/userlist.txt")
+	if !strings.HasPrefix(got, "/etc/pgbouncer/.userlist.txt.") || !strings.HasSuffix(got, ".tmp") {
+		t.Errorf("tempPath() = %v, want a hidden file in /etc/pgbouncer", got)
+	}
+}
+
+func Test_replaceScript(t *testing.T) {
+	tests := []struct {
+		name  string
+		tmp   string
+		dst   string
+		perms *Permissions
+		sudo  bool
+		want  string
+	}{
+		{
+			name: "Keep permissions",
+			tmp:  "/etc/pgbouncer/.user'list.tmp",
+			dst:  "/etc/pgbouncer/user'list",
+			want: "{ sync '/etc/pgbouncer/.user'\\''list.tmp' 2>/dev/null || sync; } && " +
+				"if [ -e '/etc/pgbouncer/user'\\''list' ]; then { chown --reference='/etc/pgbouncer/user'\\''list' '/etc/pgbouncer/.user'\\''list.tmp' || [ \"$(stat -c %u -- '/etc/pgbouncer/user'\\''list')\" = \"$(id -u)\" ]; } && chmod --reference='/etc/pgbouncer/user'\\''list' '/etc/pgbouncer/.user'\\''list.tmp'; else chmod 0640 '/etc/pgbouncer/.user'\\''list.tmp'; fi && " +
+				"mv -f -- '/etc/pgbouncer/.user'\\''list.tmp' '/etc/pgbouncer/user'\\''list'",
+		},
+		{
+			name:  "Set permissions",
+			tmp:   "/etc/pgbouncer/.userlist.tmp",
+			dst:   "/etc/pgbouncer/userlist",
+			perms: &Permissions{Owner: "postgres", Group: "pgbouncer", Mode: 0640},
+			want: "{ sync '/etc/pgbouncer/.userlist.tmp' 2>/dev/null || sync; } && " +
+				"if [ -e '/etc/pgbouncer/userlist' ]; then { chown --reference='/etc/pgbouncer/userlist' '/etc/pgbouncer/.userlist.tmp' || [ \"$(stat -c %u -- '/etc/pgbouncer/userlist')\" = \"$(id -u)\" ]; } && chmod --reference='/etc/pgbouncer/userlist' '/etc/pgbouncer/.userlist.tmp'; else chmod 0640 '/etc/pgbouncer/.userlist.tmp'; fi && " +
+				"chown 'postgres:pgbouncer' '/etc/pgbouncer/.userlist.tmp' && chmod 0640 '/etc/pgbouncer/.userlist.tmp' && " +
+				"mv -f -- '/etc/pgbouncer/.userlist.tmp' '/etc/pgbouncer/userlist'",
+		},
+		{
+			name:  "Group only",
+			tmp:   "/etc/pgbouncer/.userlist.tmp",
+			dst:   "/etc/pgbouncer/userlist",
+			perms: &Permissions{Group: "pgbouncer"},
+			want: "{ sync '/etc/pgbouncer/.userlist.tmp' 2>/dev/null || sync; } && " +
+				"if [ -e '/etc/pgbouncer/userlist' ]; then { chown --reference='/etc/pgbouncer/userlist' '/etc/pgbouncer/.userlist.tmp' || [ \"$(stat -c %u -- '/etc/pgbouncer/userlist')\" = \"$(id -u)\" ]; } && chmod --reference='/etc/pgbouncer/userlist' '/etc/pgbouncer/.userlist.tmp'; else chmod 0640 '/etc/pgbouncer/.userlist.tmp'; fi && " +
+				"chown ':pgbouncer' '/etc/pgbouncer/.userlist.tmp' && " +
+				"mv -f -- '/etc/pgbouncer/.userlist.tmp' '/etc/pgbouncer/userlist'",
+		},
+		{
+			name:  "Sudo",
+			tmp:   "/etc/pgbouncer/.userlist.tmp",
+			dst:   "/etc/pgbouncer/userlist",
+			perms: &Permissions{Owner: "postgres", Mode: 0640},
+			sudo:  true,
+			want: "{ sudo sync '/etc/pgbouncer/.userlist.tmp' 2>/dev/null || sudo sync; } && " +
+				"if [ -e '/etc/pgbouncer/userlist' ]; then { sudo chown --reference='/etc/pgbouncer/userlist' '/etc/pgbouncer/.userlist.tmp' || [ \"$(stat -c %u -- '/etc/pgbouncer/userlist')\" = \"$(id -u)\" ]; } && sudo chmod --reference='/etc/pgbouncer/userlist' '/etc/pgbouncer/.userlist.tmp'; else sudo chmod 0640 '/etc/pgbouncer/.userlist.tmp'; fi && " +
+				"sudo chown 'postgres' '/etc/pgbouncer/.userlist.tmp' && sudo chmod 0640 '/etc/pgbouncer/.userlist.tmp' && " +
+				"sudo mv -f -- '/etc/pgbouncer/.userlist.tmp' '/etc/pgbouncer/userlist'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := replaceScript(tt.tmp, tt.dst, tt.perms, tt.sudo); got != tt.want {
+				t.Errorf("replaceScript() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseStat(t *testing.T) {
+	tests := []struct {
+		out     string
+		want    *RemoteFile
+		wantErr bool
+	}{
+		{out: "postgres pgbouncer 640 1234\n", want: &RemoteFile{Owner: "postgres", Group: "pgbouncer", Mode: 0640, Size: 1234}},
+		{out: "root root 644\n", wantErr: true},
+		{out: "root root rw 12\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.out, func(t *testing.T) {
+			got, err := parseStat(tt.out)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseStat() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseStat() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPermissions_Drift(t *testing.T) {
+	file := &RemoteFile{Owner: "root", Group: "root", Mode: 0644}
+
+	tests := []struct {
+		name  string
+		perms *Permissions
+		want  []string
+	}{
+		{name: "Unset", want: []string{}},
+		{name: "Matching", perms: &Permissions{Owner: "root", Mode: 0644}, want: []string{}},
+		{
+			name:  "Drift",
+			perms: &Permissions{Owner: "postgres", Group: "pgbouncer", Mode: 0640},
+			want:  []string{"owner root, want postgres", "group root, want pgbouncer", "mode 0644, want 0640"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.perms.Drift(file); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Permissions.Drift() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	tests := []struct {
+		transport string
+		want      interface{}
+		wantErr   bool
+	}{
+		{transport: "", want: &Host{}},
+		{transport: TransportSCP, want: &Host{}},
+		{transport: TransportSFTP, want: &SftpHost{}},
+		{transport: "rsync", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.transport, func(t *testing.T) {
+			got, err := NewClient(tt.transport, "127.0.0.1:22", "pgbouncer", 22, nil, true)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewClient() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			// Permissions must not unwrap the SFTP client
+			got = got.WithPermissions(&Permissions{Mode: 0640})
+			if reflect.TypeOf(got) != reflect.TypeOf(tt.want) {
+				t.Errorf("NewClient() = %T, want %T", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseChecksum(t *testing.T) {
+	sum := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+
+	tests := []struct {
+		out     string
+		want    string
+		wantErr bool
+	}{
+		{out: sum + "  /etc/pgbouncer/userlist.txt\n", want: sum},
+		{out: "", wantErr: true},
+		{out: "sha256sum: invalid option\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.out, func(t *testing.T) {
+			got, err := parseChecksum(tt.out)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseChecksum() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("parseChecksum() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalChecksum(t *testing.T) {
+	path := t.TempDir() + "/userlist.txt"
+	if err := os.WriteFile(path, []byte("test"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := LocalChecksum(path)
+	if err != nil {
+		t.Errorf("LocalChecksum() error = %v", err)
+		return
+	}
+	if want := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"; got != want {
+		t.Errorf("LocalChecksum() = %v, want %v", got, want)
+	}
+}
+
+// execFunc answers an exec request with its output and exit status.
+type execFunc func(command string, stdout, stderr io.Writer) uint32
+
+// replyOK answers every command with ok.
+func replyOK(command string, stdout, stderr io.Writer) uint32 {
+	io.WriteString(stdout, "ok\n")
+	return 0
+}
+
+// runShell runs commands on the local machine, the test server then acts
+// as a real host.
+func runShell(command string, stdout, stderr io.Writer) uint32 {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	var exitErr *exec.ExitError
+	if err := cmd.Run(); errors.As(err, &exitErr) {
+		return uint32(exitErr.ExitCode())
+	} else if err != nil {
+		return 1
+	}
+
+	return 0
+}
+
+// newTestServer runs an SSH server answering exec requests with run and
+// serving the sftp subsystem from the local filesystem, it returns its
+// address and the number of accepted connections.
+func newTestServer(t *testing.T, run execFunc) (string, *int32) {
+	t.Helper()
+
+	hostKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	var connections int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&connections, 1)
+
+			go func() {
+				_, channels, requests, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(requests)
+
+				for newChannel := range channels {
+					if newChannel.ChannelType() == "direct-tcpip" {
+						go forward(newChannel)
+						continue
+					}
+
+					channel, requests, err := newChannel.Accept()
+					if err != nil {
+						continue
+					}
+
+					go serveSession(channel, requests, run)
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().String(), &connections
+}
+
+// serveSession answers the first exec or subsystem request of a session.
+func serveSession(channel ssh.Channel, requests <-chan *ssh.Request, run execFunc) {
+	defer channel.Close()
+
+	for request := range requests {
+		switch request.Type {
+		case "exec":
+			var payload struct{ Command string }
+			if err := ssh.Unmarshal(request.Payload, &payload); err != nil {
+				request.Reply(false, nil)
+				continue
+			}
+			request.Reply(true, nil)
+			go ssh.DiscardRequests(requests)
+
+			status := run(payload.Command, channel, channel.Stderr())
+			channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+			return
+
+		case "subsystem":
+			var payload struct{ Name string }
+			if err := ssh.Unmarshal(request.Payload, &payload); err != nil || payload.Name != "sftp" {
+				request.Reply(false, nil)
+				continue
+			}
+			request.Reply(true, nil)
+			go ssh.DiscardRequests(requests)
+
+			server, err := sftp.NewServer(channel)
+			if err != nil {
+				return
+			}
+			server.Serve()
+			server.Close()
+			return
+
+		default:
+			request.Reply(false, nil)
+		}
+	}
+}
+
+// forward serves a direct-tcpip channel, as a jump host does.
+func forward(newChannel ssh.NewChannel) {
+	var target struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &target); err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+
+	conn, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	go func() {
+		io.Copy(conn, channel)
+		conn.Close()
+	}()
+	io.Copy(channel, conn)
+	channel.Close()
+}
+
+func newTestKey(t *testing.T) []byte {
+	t.Helper()
+
+	clientKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(clientKey)})
+}
+
+func TestHost_output(t *testing.T) {
+	addr, connections := newTestServer(t, replyOK)
+	privKey := newTestKey(t)
+
+	h := NewScpClient(addr, "pgbouncer", 22, privKey, false).(*Host)
+	defer h.Close()
+
+	for i := 0; i < 3; i++ {
+		out, err := h.output(context.Background(), "true")
+		if err != nil {
+			t.Errorf("Host.output() error = %v", err)
+			return
+		}
+		if out != "ok\n" {
+			t.Errorf("Host.output() = %q, want %q", out, "ok\n")
+		}
+	}
+
+	if got := atomic.LoadInt32(connections); got != 1 {
+		t.Errorf("Host dialed %d connections, want 1", got)
+	}
+
+	h.Close()
+	if h.conn != nil || h.done != nil {
+		t.Errorf("Host.Close() left the connection open")
+	}
+}
+
+func TestHost_output_JumpHosts(t *testing.T) {
+	bastion, bastionConnections := newTestServer(t, replyOK)
+	addr, connections := newTestServer(t, replyOK)
+	privKey := newTestKey(t)
+
+	h := NewScpClient(addr, "pgbouncer", 22, privKey, false, &Hop{Addr: bastion, Username: "bastion", PrivKey: privKey}).(*Host)
+	defer h.Close()
+
+	out, err := h.output(context.Background(), "true")
+	if err != nil {
+		t.Errorf("Host.output() error = %v", err)
+		return
+	}
+	if out != "ok\n" {
+		t.Errorf("Host.output() = %q, want %q", out, "ok\n")
+	}
+
+	if got := atomic.LoadInt32(bastionConnections); got != 1 {
+		t.Errorf("Host dialed %d connections to the jump host, want 1", got)
+	}
+	if got := atomic.LoadInt32(connections); got != 1 {
+		t.Errorf("Host dialed %d connections, want 1", got)
+	}
+
+	h.Close()
+	if h.jumpClients != nil {
+		t.Errorf("Host.Close() left the jump hosts connected")
+	}
+}
+
+func TestHost_output_JumpHostDown(t *testing.T) {
+	addr, _ := newTestServer(t, replyOK)
+	privKey := newTestKey(t)
+
+	h := NewScpClient(addr, "pgbouncer", 22, privKey, false, &Hop{Addr: "127.0.0.1:1", Username: "bastion", PrivKey: privKey}).(*Host)
+	defer h.Close()
+
+	if _, err := h.output(context.Background(), "true"); err == nil || !strings.Contains(err.Error(), "jump host 127.0.0.1:1") {
+		t.Errorf("Host.output() error = %v, want a jump host error", err)
+	}
+}
+
+func TestTunnel_Dial(t *testing.T) {
+	first, _ := newTestServer(t, replyOK)
+	second, _ := newTestServer(t, replyOK)
+	addr, _ := newTestServer(t, replyOK)
+	privKey := newTestKey(t)
+
+	tunnel := NewTunnel(
+		&Hop{Addr: first, Username: "first", PrivKey: privKey},
+		&Hop{Addr: second, Username: "second", PrivKey: privKey},
+	)
+	defer tunnel.Close()
+
+	conn, err := tunnel.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		t.Errorf("Tunnel.DialTimeout() error = %v", err)
+		return
+	}
+	defer conn.Close()
+
+	// The target is an SSH server, it speaks first
+	banner := make([]byte, 4)
+	if _, err := io.ReadFull(conn, banner); err != nil {
+		t.Errorf("reading through the tunnel: %v", err)
+		return
+	}
+	if string(banner) != "SSH-" {
+		t.Errorf("read %q through the tunnel, want %q", banner, "SSH-")
+	}
+}
+
+func TestHost_DialTimeout(t *testing.T) {
+	addr, connections := newTestServer(t, replyOK)
+	target, _ := newTestServer(t, replyOK)
+
+	h := NewScpClient(addr, "pgbouncer", 22, newTestKey(t), false).(*Host)
+	defer h.Close()
+
+	// Commands and forwarded connections share the SSH connection
+	if err := h.Run(context.Background(), "true"); err != nil {
+		t.Errorf("Host.Run() error = %v", err)
+		return
+	}
+
+	conn, err := h.DialTimeout("tcp", target, 5*time.Second)
+	if err != nil {
+		t.Errorf("Host.DialTimeout() error = %v", err)
+		return
+	}
+	defer conn.Close()
+
+	banner := make([]byte, 4)
+	if _, err := io.ReadFull(conn, banner); err != nil {
+		t.Errorf("reading from the host: %v", err)
+		return
+	}
+	if string(banner) != "SSH-" {
+		t.Errorf("read %q from the host, want %q", banner, "SSH-")
+	}
+
+	if got := atomic.LoadInt32(connections); got != 1 {
+		t.Errorf("Host dialed %d connections, want 1", got)
+	}
+}
+
+// fakeRemote serves Stat and RemoteChecksum from memory.
+type fakeRemote struct {
+	Scp
+	content []byte
+}
+
+func (f *fakeRemote) Stat(ctx context.Context, remotePath string) (*RemoteFile, error) {
+	if f.content == nil {
+		return nil, os.ErrNotExist
+	}
+
+	return &RemoteFile{Size: int64(len(f.content))}, nil
+}
+
+func (f *fakeRemote) RemoteChecksum(ctx context.Context, remotePath string) (string, error) {
+	sum := sha256.Sum256(f.content)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func TestVerify(t *testing.T) {
+	localPath := filepath.Join(t.TempDir(), "userlist.txt")
+	if err := os.WriteFile(localPath, []byte("\"app\" \"md5app\"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		remote     []byte
+		wantVerify bool
+		wantErr    bool
+	}{
+		{
+			name:   "Same file",
+			remote: []byte("\"app\" \"md5app\"\n"),
+		},
+		{
+			name:       "Truncated",
+			remote:     []byte("\"app\" \"md5"),
+			wantVerify: true,
+			wantErr:    true,
+		},
+		{
+			name:       "Same size, other content",
+			remote:     []byte("\"app\" \"md5bad\"\n"),
+			wantVerify: true,
+			wantErr:    true,
+		},
+		{
+			name:    "Missing",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(context.Background(), &fakeRemote{content: tt.remote}, localPath, "/etc/pgbouncer/userlist.txt")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Verify() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if errors.Is(err, ErrVerify) != tt.wantVerify {
+				t.Errorf("Verify() error = %v, want ErrVerify %v", err, tt.wantVerify)
+			}
+		})
+	}
+}
+
+func newTestSftpHost(t *testing.T, run execFunc) *SftpHost {
+	t.Helper()
+
+	addr, _ := newTestServer(t, run)
+	s := NewSftpClient(addr, "pgbouncer", 22, newTestKey(t), false).(*SftpHost)
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func TestSftpHost_SaveOld(t *testing.T) {
+	s := newTestSftpHost(t, replyOK)
+	remoteDir, localDir := t.TempDir(), t.TempDir()
+
+	remotePath := filepath.Join(remoteDir, "userlist.txt")
+	if err := os.WriteFile(remotePath, []byte("\"app\" \"md5app\"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	localPath := filepath.Join(localDir, "userlist.txt.old")
+	if err := s.SaveOld(context.Background(), localPath, remotePath); err != nil {
+		t.Errorf("SftpHost.SaveOld() error = %v", err)
+		return
+	}
+
+	if got, _ := os.ReadFile(localPath); string(got) != "\"app\" \"md5app\"\n" {
+		t.Errorf("SftpHost.SaveOld() saved %q, want %q", got, "\"app\" \"md5app\"\n")
+	}
+
+	if err := s.SaveOld(context.Background(), localPath, filepath.Join(remoteDir, "missing")); err == nil {
+		t.Errorf("SftpHost.SaveOld() error = nil for a missing file")
+	}
+}
+
+func TestSftpHost_Copy(t *testing.T) {
+	localPath := filepath.Join(t.TempDir(), "userlist.txt")
+	if err := os.WriteFile(localPath, []byte("\"app\" \"md5new\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		old      []byte
+		oldMode  os.FileMode
+		perms    *Permissions
+		wantMode os.FileMode
+	}{
+		{
+			name:     "Keep permissions",
+			old:      []byte("\"app\" \"md5old\"\n"),
+			oldMode:  0640,
+			wantMode: 0640,
+		},
+		{
+			name:     "Set mode",
+			old:      []byte("\"app\" \"md5old\"\n"),
+			oldMode:  0640,
+			perms:    &Permissions{Mode: 0644},
+			wantMode: 0644,
+		},
+		{
+			name:     "New file",
+			wantMode: newFileMode,
+		},
+		{
+			name:     "New file, set mode",
+			perms:    &Permissions{Mode: 0600},
+			wantMode: 0600,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSftpHost(t, runShell)
+			s.WithPermissions(tt.perms)
+
+			remoteDir := t.TempDir()
+			remotePath := filepath.Join(remoteDir, "userlist.txt")
+			if tt.old != nil {
+				if err := os.WriteFile(remotePath, tt.old, tt.oldMode); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := s.Copy(context.Background(), localPath, remotePath); err != nil {
+				t.Errorf("SftpHost.Copy() error = %v", err)
+				return
+			}
+
+			if got, _ := os.ReadFile(remotePath); string(got) != "\"app\" \"md5new\"\n" {
+				t.Errorf("SftpHost.Copy() wrote %q, want %q", got, "\"app\" \"md5new\"\n")
+			}
+
+			info, err := os.Stat(remotePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != tt.wantMode {
+				t.Errorf("SftpHost.Copy() mode = %04o, want %04o", info.Mode().Perm(), tt.wantMode)
+			}
+
+			entries, err := os.ReadDir(remoteDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 {
+				t.Errorf("SftpHost.Copy() left %d files, want only the destination", len(entries))
+			}
+		})
+	}
+}
+
+func TestSftpHost_upload_Exclusive(t *testing.T) {
+	s := newTestSftpHost(t, replyOK)
+
+	tmpPath := filepath.Join(t.TempDir(), ".userlist.txt.tmp")
+	if err := os.WriteFile(tmpPath, []byte("planted"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s.file = &File{mode: tempMode, content: strings.NewReader("\"app\" \"md5new\"\n")}
+	if err := s.upload(context.Background(), tmpPath); err == nil {
+		t.Errorf("SftpHost.upload() error = nil over an existing file")
+	}
+
+	if got, _ := os.ReadFile(tmpPath); string(got) != "planted" {
+		t.Errorf("SftpHost.upload() overwrote an existing file with %q", got)
+	}
+}
+
+func TestSftpHost_RemoteChecksum(t *testing.T) {
+	remotePath := filepath.Join(t.TempDir(), "userlist.txt")
+	if err := os.WriteFile(remotePath, []byte("test"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		run     execFunc
+		wantErr bool
+	}{
+		{
+			name: "sha256sum",
+			run:  runShell,
+		},
+		{
+			name: "Download without sha256sum",
+			run: func(command string, stdout, stderr io.Writer) uint32 {
+				io.WriteString(stderr, "sh: sha256sum: not found\n")
+				return commandNotFound
+			},
+		},
+		{
+			name: "Failed",
+			run: func(command string, stdout, stderr io.Writer) uint32 {
+				io.WriteString(stderr, "sha256sum: permission denied\n")
+				return 1
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSftpHost(t, tt.run)
+
+			got, err := s.RemoteChecksum(context.Background(), remotePath)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SftpHost.RemoteChecksum() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if want := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"; !tt.wantErr && got != want {
+				t.Errorf("SftpHost.RemoteChecksum() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestSftpHost_Copy_Verify(t *testing.T) {
+	localPath := filepath.Join(t.TempDir(), "userlist.txt")
+	if err := os.WriteFile(localPath, []byte("\"app\" \"md5new\"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		run         execFunc
+		wantContent string
+		wantErr     bool
+	}{
+		{
+			name:        "Verified",
+			run:         runShell,
+			wantContent: "\"app\" \"md5new\"\n",
+		},
+		{
+			name: "Corrupt upload",
+			run: func(command string, stdout, stderr io.Writer) uint32 {
+				if strings.Contains(command, "sha256sum") {
+					io.WriteString(stdout, strings.Repeat("0", 64)+"  upload\n")
+					return 0
+				}
+				return runShell(command, stdout, stderr)
+			},
+			wantContent: "\"app\" \"md5old\"\n",
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSftpHost(t, tt.run)
+			s.WithVerify(true)
+
+			remoteDir := t.TempDir()
+			remotePath := filepath.Join(remoteDir, "userlist.txt")
+			if err := os.WriteFile(remotePath, []byte("\"app\" \"md5old\"\n"), 0640); err != nil {
+				t.Fatal(err)
+			}
+
+			err := s.Copy(context.Background(), localPath, remotePath)
+			if (err != nil) != tt.wantErr || (err != nil && !errors.Is(err, ErrVerify)) {
+				t.Errorf("SftpHost.Copy() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if got, _ := os.ReadFile(remotePath); string(got) != tt.wantContent {
+				t.Errorf("SftpHost.Copy() left %q, want %q", got, tt.wantContent)
+			}
+
+			entries, err := os.ReadDir(remoteDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 {
+				t.Errorf("SftpHost.Copy() left %d files, want only the destination", len(entries))
+			}
+		})
+	}
+}
+
+// runShellWithout runs commands like runShell, with commands failing as
+// they do for a user missing the rights.
+func runShellWithout(t *testing.T, commands ...string) execFunc {
+	t.Helper()
+
+	bin := t.TempDir()
+	for _, command := range commands {
+		script := "#!/bin/sh\necho \"" + command + ": Operation not permitted\" >&2\nexit 1\n"
+		if err := os.WriteFile(filepath.Join(bin, command), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return func(command string, stdout, stderr io.Writer) uint32 {
+		return runShell("PATH="+ShellQuote(bin)+":\"$PATH\"; "+command, stdout, stderr)
+	}
+}
+
+func TestSftpHost_Copy_NoChown(t *testing.T) {
+	localPath := filepath.Join(t.TempDir(), "userlist.txt")
+	if err := os.WriteFile(localPath, []byte("\"app\" \"md5new\"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		otherOwner  bool
+		wantContent string
+		wantErr     bool
+	}{
+		{
+			name:        "Own file",
+			wantContent: "\"app\" \"md5new\"\n",
+		},
+		{
+			name:        "File of another user",
+			otherOwner:  true,
+			wantContent: "\"app\" \"md5old\"\n",
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSftpHost(t, runShellWithout(t, "chown"))
+
+			remoteDir := t.TempDir()
+			remotePath := filepath.Join(remoteDir, "userlist.txt")
+			if err := os.WriteFile(remotePath, []byte("\"app\" \"md5old\"\n"), 0640); err != nil {
+				t.Fatal(err)
+			}
+			if tt.otherOwner {
+				if err := os.Chown(remotePath, os.Getuid()+1, -1); err != nil {
+					t.Skip("can't give the file to another user: ", err)
+				}
+			}
+
+			err := s.Copy(context.Background(), localPath, remotePath)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SftpHost.Copy() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if got, _ := os.ReadFile(remotePath); string(got) != tt.wantContent {
+				t.Errorf("SftpHost.Copy() left %q, want %q", got, tt.wantContent)
+			}
+
+			entries, err := os.ReadDir(remoteDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 {
+				t.Errorf("SftpHost.Copy() left %d files, want only the destination", len(entries))
+			}
+		})
+	}
+}
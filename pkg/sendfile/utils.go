@@ -2,7 +2,20 @@ package sendfile
 
 import (
 	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	sha256Hex = regexp.MustCompile(`^[0-9a-f]{64}$`)
 )
 
 func copyN(writer io.Writer, src io.Reader, size int64) (int64, error) {
@@ -27,3 +40,135 @@ func md5sum(file io.Reader) ([]byte, error) {
 
 	return hash.Sum(nil), nil
 }
+
+const (
+	// tempMode is the mode of uploads before they replace the destination
+	tempMode os.FileMode = 0600
+	// newFileMode is the mode of a file created on the host, unless one is
+	// configured, group readable for PgBouncer
+	newFileMode os.FileMode = 0640
+)
+
+// tempPath returns a hidden file name in the directory of path, rename is
+// only atomic within a filesystem.
+func tempPath(path string) string {
+	return filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s.%d.tmp", filepath.Base(path), time.Now().UnixNano()))
+}
+
+// replaceScript flushes tmpPath to disk, gives it the owner and mode of
+// path when path exists or newFileMode then perms, and renames it over path.
+// A user that can't chown only replaces files it owns, others would lose
+// access. With sudo, each command goes through sudo.
+func replaceScript(tmpPath, path string, perms *Permissions, sudo bool) string {
+	tmp, dst := ShellQuote(tmpPath), ShellQuote(path)
+
+	root := ""
+	if sudo {
+		root = "sudo "
+	}
+
+	steps := []string{
+		fmt.Sprintf("{ %[2]ssync %[1]s 2>/dev/null || %[2]ssync; }", tmp, root),
+		fmt.Sprintf(`if [ -e %[2]s ]; then { %[4]schown --reference=%[2]s %[1]s || [ "$(stat -c %%u -- %[2]s)" = "$(id -u)" ]; } && %[4]schmod --reference=%[2]s %[1]s; else %[4]schmod %04[3]o %[1]s; fi`, tmp, dst, newFileMode, root),
+	}
+
+	if perms != nil {
+		if owner := perms.chown(); owner != "" {
+			steps = append(steps, fmt.Sprintf("%schown %s %s", root, ShellQuote(owner), tmp))
+		}
+
+		if perms.Mode != 0 {
+			steps = append(steps, fmt.Sprintf("%schmod %04o %s", root, perms.Mode.Perm(), tmp))
+		}
+	}
+
+	steps = append(steps, fmt.Sprintf("%smv -f -- %s %s", root, tmp, dst))
+	return strings.Join(steps, " && ")
+}
+
+// chown returns the owner[:group] argument of chown, empty when unset.
+func (p *Permissions) chown() string {
+	if p.Group == "" {
+		return p.Owner
+	}
+
+	return p.Owner + ":" + p.Group
+}
+
+// Drift lists how f differs from the permissions.
+func (p *Permissions) Drift(f *RemoteFile) []string {
+	drift := []string{}
+	if p == nil {
+		return drift
+	}
+
+	if p.Owner != "" && f.Owner != p.Owner {
+		drift = append(drift, fmt.Sprintf("owner %s, want %s", f.Owner, p.Owner))
+	}
+
+	if p.Group != "" && f.Group != p.Group {
+		drift = append(drift, fmt.Sprintf("group %s, want %s", f.Group, p.Group))
+	}
+
+	if p.Mode != 0 && f.Mode.Perm() != p.Mode.Perm() {
+		drift = append(drift, fmt.Sprintf("mode %04o, want %04o", f.Mode.Perm(), p.Mode.Perm()))
+	}
+
+	return drift
+}
+
+// parseStat reads the output of stat -c '%U %G %a %s'.
+func parseStat(out string) (*RemoteFile, error) {
+	fields := strings.Fields(out)
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("unexpected stat output %q", out)
+	}
+
+	mode, err := strconv.ParseUint(fields[2], 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected stat mode %q", fields[2])
+	}
+
+	size, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected stat size %q", fields[3])
+	}
+
+	return &RemoteFile{
+		Owner: fields[0],
+		Group: fields[1],
+		Mode:  os.FileMode(mode),
+		Size:  size,
+	}, nil
+}
+
+// ShellQuote quotes s as a single POSIX shell word.
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// LocalChecksum returns the hex sha256 of a local file.
+func LocalChecksum(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// parseChecksum reads the checksum of a sha256sum output line.
+func parseChecksum(out string) (string, error) {
+	fields := strings.Fields(out)
+	if len(fields) == 0 || !sha256Hex.MatchString(fields[0]) {
+		return "", fmt.Errorf("unexpected sha256sum output %q", out)
+	}
+
+	return fields[0], nil
+}
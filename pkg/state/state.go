@@ -0,0 +1,22 @@
+package state
+
+type States interface {
+	// Unchanged reports whether fingerprint matches the last successful push
+	// to host
+	Unchanged(host, fingerprint string) bool
+	// Pushed records fingerprint as successfully pushed to host
+	Pushed(host, fingerprint string)
+	// ReloadFailed records that host still has to be reloaded, until the
+	// next push
+	ReloadFailed(host string)
+	// ReloadPending reports whether the last reload of host failed
+	ReloadPending(host string) bool
+	// Save writes the recorded pushes, other hosts keep their last state
+	Save() error
+}
+
+func NewStateFromFile(filePath string) States {
+	return &State{
+		filePath: filePath,
+	}
+}
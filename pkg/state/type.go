@@ -0,0 +1,159 @@
+package state
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+type State struct {
+	filePath string
+	loaded   bool
+	Hosts    map[string]*HostState `yaml:"hosts"`
+}
+
+// HostState is the last successful push to a host.
+type HostState struct {
+	Fingerprint string    `yaml:"fingerprint"`
+	PushedAt    time.Time `yaml:"pushed_at"`
+	// The pushed files are not loaded by PGBouncer yet
+	ReloadPending bool `yaml:"reload_pending,omitempty"`
+}
+
+func (s *State) Unchanged(host, fingerprint string) bool {
+	s.loadOnce()
+
+	last, ok := s.Hosts[host]
+	return ok && last.Fingerprint != "" && last.Fingerprint == fingerprint
+}
+
+func (s *State) Pushed(host, fingerprint string) {
+	s.loadOnce()
+
+	s.Hosts[host] = &HostState{
+		Fingerprint: fingerprint,
+		PushedAt:    time.Now().UTC(),
+	}
+}
+
+func (s *State) ReloadFailed(host string) {
+	s.loadOnce()
+
+	if s.Hosts[host] == nil {
+		s.Hosts[host] = &HostState{}
+	}
+	s.Hosts[host].ReloadPending = true
+}
+
+func (s *State) ReloadPending(host string) bool {
+	s.loadOnce()
+
+	last, ok := s.Hosts[host]
+	return ok && last.ReloadPending
+}
+
+func (s *State) Save() error {
+	s.loadOnce()
+
+	out, err := yaml.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	// Write then rename so a crash never leaves a truncated state file
+	tmp, err := os.CreateTemp(filepath.Dir(s.filePath), filepath.Base(s.filePath)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(out); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.filePath)
+}
+
+// loadOnce reads the state file on first use, a missing or unreadable file
+// is an empty state and every host counts as changed.
+func (s *State) loadOnce() {
+	if s.loaded {
+		return
+	}
+	s.loaded = true
+
+	if err := s.load(); err != nil {
+		if !os.IsNotExist(err) {
+			log.Warn("Failed to read state file ", s.filePath, ": ", err)
+		}
+		s.Hosts = nil
+	}
+
+	if s.Hosts == nil {
+		s.Hosts = map[string]*HostState{}
+	}
+}
+
+func (s *State) load() error {
+	in, err := os.ReadFile(s.filePath)
+	if err != nil {
+		return err
+	}
+
+	return yaml.Unmarshal(in, s)
+}
+
+// Fingerprint hashes a role set row by row, rows must be added in role name
+// order for two identical sets to share the same fingerprint.
+type Fingerprint struct {
+	hash hash.Hash
+}
+
+func NewFingerprint() *Fingerprint {
+	return &Fingerprint{
+		hash: sha256.New(),
+	}
+}
+
+func (f *Fingerprint) Add(fields ...string) {
+	var size [8]byte
+	for _, field := range fields {
+		// Length prefix keeps ("ab", "c") and ("a", "bc") apart
+		binary.BigEndian.PutUint64(size[:], uint64(len(field)))
+		f.hash.Write(size[:])
+		f.hash.Write([]byte(field))
+	}
+}
+
+func (f *Fingerprint) String() string {
+	return hex.EncodeToString(f.hash.Sum(nil))
+}
+
+// FingerprintOf returns the fingerprint of a role name to password map.
+func FingerprintOf(roles map[string]string) string {
+	names := make([]string, 0, len(roles))
+	for name := range roles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fp := NewFingerprint()
+	for _, name := range names {
+		fp.Add(name, roles[name])
+	}
+
+	return fp.String()
+}
@@ -0,0 +1,137 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFingerprintOf(t *testing.T) {
+	base := FingerprintOf(map[string]string{
+		"postgres": "md5aaa",
+		"app":      "md5bbb",
+	})
+
+	tests := []struct {
+		name  string
+		roles map[string]string
+		same  bool
+	}{
+		{
+			name: "Same roles",
+			roles: map[string]string{
+				"app":      "md5bbb",
+				"postgres": "md5aaa",
+			},
+			same: true,
+		},
+		{
+			name: "Password changed",
+			roles: map[string]string{
+				"app":      "md5ccc",
+				"postgres": "md5aaa",
+			},
+			same: false,
+		},
+		{
+			name: "Role removed",
+			roles: map[string]string{
+				"postgres": "md5aaa",
+			},
+			same: false,
+		},
+		{
+			name: "Fields shifted",
+			roles: map[string]string{
+				"ap":       "pmd5bbb",
+				"postgres": "md5aaa",
+			},
+			same: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FingerprintOf(tt.roles) == base; got != tt.same {
+				t.Errorf("FingerprintOf() same = %v, want %v", got, tt.same)
+			}
+		})
+	}
+}
+
+func TestState_Unchanged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.yaml")
+
+	if NewStateFromFile(path).Unchanged("pgbouncer-01", "abc") {
+		t.Errorf("State.Unchanged() = true without state file")
+	}
+
+	saved := NewStateFromFile(path)
+	saved.Pushed("pgbouncer-01", "abc")
+	if err := saved.Save(); err != nil {
+		t.Fatalf("State.Save() error = %v", err)
+	}
+
+	if !NewStateFromFile(path).Unchanged("pgbouncer-01", "abc") {
+		t.Errorf("State.Unchanged() = false with the saved fingerprint")
+	}
+
+	if NewStateFromFile(path).Unchanged("pgbouncer-01", "def") {
+		t.Errorf("State.Unchanged() = true with another fingerprint")
+	}
+
+	if NewStateFromFile(path).Unchanged("pgbouncer-02", "abc") {
+		t.Errorf("State.Unchanged() = true for a host never pushed to")
+	}
+
+	// Hosts not pushed to keep their state
+	other := NewStateFromFile(path)
+	other.Pushed("pgbouncer-02", "def")
+	if err := other.Save(); err != nil {
+		t.Fatalf("State.Save() error = %v", err)
+	}
+
+	reloaded := NewStateFromFile(path)
+	if !reloaded.Unchanged("pgbouncer-01", "abc") || !reloaded.Unchanged("pgbouncer-02", "def") {
+		t.Errorf("State.Save() lost a host state")
+	}
+}
+
+func TestState_UnchangedOldFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.yaml")
+	if err := os.WriteFile(path, []byte("fingerprint: abc\npushed_at: 2024-01-01T00:00:00Z\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if NewStateFromFile(path).Unchanged("pgbouncer-01", "abc") {
+		t.Errorf("State.Unchanged() = true with a state file without hosts")
+	}
+}
+
+func TestState_ReloadPending(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.yaml")
+
+	failed := NewStateFromFile(path)
+	failed.Pushed("pgbouncer-01", "abc")
+	failed.ReloadFailed("pgbouncer-01")
+	failed.Pushed("pgbouncer-02", "abc")
+	if err := failed.Save(); err != nil {
+		t.Fatalf("State.Save() error = %v", err)
+	}
+
+	saved := NewStateFromFile(path)
+	if !saved.ReloadPending("pgbouncer-01") {
+		t.Errorf("State.ReloadPending() = false after a failed reload")
+	}
+	if saved.ReloadPending("pgbouncer-02") || saved.ReloadPending("pgbouncer-03") {
+		t.Errorf("State.ReloadPending() = true without a failed reload")
+	}
+	if !saved.Unchanged("pgbouncer-01", "abc") {
+		t.Errorf("State.Unchanged() = false for a pushed host pending reload")
+	}
+
+	// The next push clears it
+	saved.Pushed("pgbouncer-01", "abc")
+	if saved.ReloadPending("pgbouncer-01") {
+		t.Errorf("State.ReloadPending() = true after a push")
+	}
+}
@@ -0,0 +1,102 @@
+package userlist
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	// Markers are comments, PGBouncer rejects an auth file line starting
+	// with anything but a double quote or a semicolon
+	BeginMarker = "; BEGIN pgbouncer-updater"
+	EndMarker   = "; END pgbouncer-updater"
+)
+
+var (
+	ErrMarkers = errors.New("malformed managed block markers")
+)
+
+// ReplaceBlock copies current to w with the lines between BeginMarker and
+// EndMarker replaced by block, lines outside the markers are kept as is.
+// current must hold exactly one begin marker followed by one end marker.
+func ReplaceBlock(w io.Writer, current, block io.Reader) error {
+	lines, err := readLines(current)
+	if err != nil {
+		return err
+	}
+
+	begin, end := -1, -1
+	for i, line := range lines {
+		switch strings.TrimSpace(line) {
+		case BeginMarker:
+			if begin >= 0 {
+				return fmt.Errorf("%w: line %d: second %q", ErrMarkers, i+1, BeginMarker)
+			}
+			begin = i
+		case EndMarker:
+			if begin < 0 {
+				return fmt.Errorf("%w: line %d: %q before %q", ErrMarkers, i+1, EndMarker, BeginMarker)
+			}
+			if end >= 0 {
+				return fmt.Errorf("%w: line %d: second %q", ErrMarkers, i+1, EndMarker)
+			}
+			end = i
+		}
+	}
+
+	if begin < 0 || end < 0 {
+		return fmt.Errorf("%w: expected %q and %q lines", ErrMarkers, BeginMarker, EndMarker)
+	}
+
+	blockLines, err := readLines(block)
+	if err != nil {
+		return err
+	}
+
+	for i, line := range blockLines {
+		switch strings.TrimSpace(line) {
+		case BeginMarker, EndMarker:
+			return fmt.Errorf("%w: generated line %d is a marker", ErrMarkers, i+1)
+		}
+	}
+
+	buf := bufio.NewWriter(w)
+	for _, line := range lines[:begin+1] {
+		buf.WriteString(line)
+	}
+	for _, line := range blockLines {
+		buf.WriteString(line)
+	}
+	for _, line := range lines[end:] {
+		buf.WriteString(line)
+	}
+
+	return buf.Flush()
+}
+
+// readLines splits r into lines, each one ending with a line feed.
+func readLines(r io.Reader) ([]string, error) {
+	lines := []string{}
+
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		if line != "" {
+			if !strings.HasSuffix(line, "\n") {
+				line += "\n"
+			}
+			lines = append(lines, line)
+		}
+
+		if err == io.EOF {
+			return lines, nil
+		}
+	}
+}
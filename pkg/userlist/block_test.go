@@ -0,0 +1,81 @@
+package userlist
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestReplaceBlock(t *testing.T) {
+	block := "\"app\" \"md5new\"\n\"report\" \"md5report\""
+
+	tests := []struct {
+		name    string
+		current string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "Keep local lines",
+			current: "\"local\" \"md5local\"\n; BEGIN pgbouncer-updater\n\"app\" \"md5old\"\n; END pgbouncer-updater\n; kept\n\"other\" \"md5other\"",
+			want:    "\"local\" \"md5local\"\n; BEGIN pgbouncer-updater\n\"app\" \"md5new\"\n\"report\" \"md5report\"\n; END pgbouncer-updater\n; kept\n\"other\" \"md5other\"\n",
+		},
+		{
+			name:    "Empty block",
+			current: "; BEGIN pgbouncer-updater\n  ; END pgbouncer-updater  \n",
+			want:    "; BEGIN pgbouncer-updater\n\"app\" \"md5new\"\n\"report\" \"md5report\"\n  ; END pgbouncer-updater  \n",
+		},
+		{
+			name:    "No markers",
+			current: "\"local\" \"md5local\"\n",
+			wantErr: true,
+		},
+		{
+			name:    "Missing end",
+			current: "; BEGIN pgbouncer-updater\n\"app\" \"md5old\"\n",
+			wantErr: true,
+		},
+		{
+			name:    "End before begin",
+			current: "; END pgbouncer-updater\n; BEGIN pgbouncer-updater\n",
+			wantErr: true,
+		},
+		{
+			name:    "Two blocks",
+			current: "; BEGIN pgbouncer-updater\n; END pgbouncer-updater\n; BEGIN pgbouncer-updater\n; END pgbouncer-updater\n",
+			wantErr: true,
+		},
+		{
+			name:    "Hash markers",
+			current: "# BEGIN pgbouncer-updater\n# END pgbouncer-updater\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := new(bytes.Buffer)
+			err := ReplaceBlock(w, strings.NewReader(tt.current), strings.NewReader(block))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ReplaceBlock() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				if !errors.Is(err, ErrMarkers) {
+					t.Errorf("ReplaceBlock() error = %v, want %v", err, ErrMarkers)
+				}
+				return
+			}
+			if got := w.String(); got != tt.want {
+				t.Errorf("ReplaceBlock() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplaceBlock_MarkerInBlock(t *testing.T) {
+	err := ReplaceBlock(new(bytes.Buffer), strings.NewReader("; BEGIN pgbouncer-updater\n; END pgbouncer-updater\n"), strings.NewReader("; END pgbouncer-updater\n"))
+	if !errors.Is(err, ErrMarkers) {
+		t.Errorf("ReplaceBlock() error = %v, want %v", err, ErrMarkers)
+	}
+}
@@ -0,0 +1,151 @@
+package userlist
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Changes lists role names only, verifiers never leave Diff.
+type Changes struct {
+	Added   []string
+	Removed []string
+	Changed []string
+}
+
+// Diff compares two lists by user name, when a name appears twice the last
+// entry wins as it does for PGBouncer.
+func Diff(old, new []*User) *Changes {
+	oldUsers := byName(old)
+	newUsers := byName(new)
+
+	changes := &Changes{
+		Added:   []string{},
+		Removed: []string{},
+		Changed: []string{},
+	}
+
+	for name, md5 := range newUsers {
+		oldMd5, found := oldUsers[name]
+		switch {
+		case !found:
+			changes.Added = append(changes.Added, name)
+		case oldMd5 != md5:
+			changes.Changed = append(changes.Changed, name)
+		}
+	}
+
+	for name := range oldUsers {
+		if _, found := newUsers[name]; !found {
+			changes.Removed = append(changes.Removed, name)
+		}
+	}
+
+	sort.Strings(changes.Added)
+	sort.Strings(changes.Removed)
+	sort.Strings(changes.Changed)
+
+	return changes
+}
+
+func (c *Changes) Empty() bool {
+	return len(c.Added) == 0 && len(c.Removed) == 0 && len(c.Changed) == 0
+}
+
+// String summarizes changes as "1 added (app), 2 removed (a, b), 0 changed".
+func (c *Changes) String() string {
+	if c.Empty() {
+		return "no role changes"
+	}
+
+	return strings.Join([]string{
+		summary("added", c.Added),
+		summary("removed", c.Removed),
+		summary("changed", c.Changed),
+	}, ", ")
+}
+
+func summary(kind string, names []string) string {
+	if len(names) == 0 {
+		return fmt.Sprintf("0 %s", kind)
+	}
+
+	return fmt.Sprintf("%d %s (%s)", len(names), kind, strings.Join(names, ", "))
+}
+
+func byName(users []*User) map[string]string {
+	list := make(map[string]string, len(users))
+	for _, user := range users {
+		list[user.UserName] = user.Md5
+	}
+
+	return list
+}
+
+// WriteDiff prints the role level differences from old to new, verifiers
+// are masked.
+func WriteDiff(w io.Writer, old, new []*User) (*Changes, error) {
+	oldUsers := byName(old)
+	newUsers := byName(new)
+	changes := Diff(old, new)
+
+	lines := []string{}
+	for _, name := range changes.Added {
+		lines = append(lines, fmt.Sprintf("+ %s\t%s", name, Mask(newUsers[name])))
+	}
+
+	for _, name := range changes.Removed {
+		lines = append(lines, fmt.Sprintf("- %s\t%s", name, Mask(oldUsers[name])))
+	}
+
+	for _, name := range changes.Changed {
+		lines = append(lines, fmt.Sprintf("~ %s\t%s -> %s", name, Mask(oldUsers[name]), Mask(newUsers[name])))
+	}
+
+	// Sort by role name whatever the kind of change
+	sort.SliceStable(lines, func(i, j int) bool {
+		return lines[i][2:] < lines[j][2:]
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(tw, line); err != nil {
+			return nil, err
+		}
+	}
+
+	return changes, tw.Flush()
+}
+
+// maskKey keys the digests of Mask, it changes every run so masked output
+// can't be matched against hashes of guessed passwords.
+var maskKey = func() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}()
+
+// Mask returns the kind of a verifier and a short keyed digest of it,
+// enough to tell two verifiers apart within a run without disclosing them.
+func Mask(verifier string) string {
+	kind := "plain"
+	switch {
+	case verifier == "":
+		kind = "empty"
+	case strings.HasPrefix(verifier, "md5") && len(verifier) == 35:
+		kind = "md5"
+	case strings.HasPrefix(verifier, "SCRAM-SHA-256$"):
+		kind = "SCRAM-SHA-256"
+	}
+
+	mac := hmac.New(sha256.New, maskKey)
+	mac.Write([]byte(verifier))
+	return fmt.Sprintf("%s #%x", kind, mac.Sum(nil)[:3])
+}
@@ -0,0 +1,136 @@
+package userlist
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	old := []*User{
+		{UserName: "app", Md5: "md5app"},
+		{UserName: "batch", Md5: "md5batch"},
+		{UserName: "legacy", Md5: "md5legacy"},
+	}
+
+	tests := []struct {
+		name        string
+		new         []*User
+		want        *Changes
+		wantSummary string
+	}{
+		{
+			name: "Same",
+			new: []*User{
+				{UserName: "legacy", Md5: "md5legacy"},
+				{UserName: "app", Md5: "md5app"},
+				{UserName: "batch", Md5: "md5batch"},
+			},
+			want:        &Changes{Added: []string{}, Removed: []string{}, Changed: []string{}},
+			wantSummary: "no role changes",
+		},
+		{
+			name: "Added removed changed",
+			new: []*User{
+				{UserName: "app", Md5: "SCRAM-SHA-256$app"},
+				{UserName: "batch", Md5: "md5batch"},
+				{UserName: "report", Md5: "md5report"},
+			},
+			want: &Changes{
+				Added:   []string{"report"},
+				Removed: []string{"legacy"},
+				Changed: []string{"app"},
+			},
+			wantSummary: "1 added (report), 1 removed (legacy), 1 changed (app)",
+		},
+		{
+			name: "Last duplicate wins",
+			new: []*User{
+				{UserName: "app", Md5: "md5old"},
+				{UserName: "app", Md5: "md5app"},
+				{UserName: "batch", Md5: "md5batch"},
+				{UserName: "legacy", Md5: "md5legacy"},
+			},
+			want:        &Changes{Added: []string{}, Removed: []string{}, Changed: []string{}},
+			wantSummary: "no role changes",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Diff(old, tt.new)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff() = %v, want %v", got, tt.want)
+			}
+			if got.String() != tt.wantSummary {
+				t.Errorf("Changes.String() = %q, want %q", got.String(), tt.wantSummary)
+			}
+		})
+	}
+}
+
+func TestWriteDiff(t *testing.T) {
+	old := []*User{
+		{UserName: "app", Md5: "md5d41d8cd98f00b204e9800998ecf8427e"},
+		{UserName: "legacy", Md5: "md5a41d8cd98f00b204e9800998ecf8427e"},
+	}
+	current := []*User{
+		{UserName: "app", Md5: "SCRAM-SHA-256$4096:salt$stored:server"},
+		{UserName: "batch", Md5: "secret"},
+	}
+
+	buf := new(bytes.Buffer)
+	changes, err := WriteDiff(buf, old, current)
+	if err != nil {
+		t.Fatalf("WriteDiff() error = %v", err)
+	}
+	if changes.Empty() {
+		t.Errorf("WriteDiff() changes are empty")
+	}
+
+	got := buf.String()
+	want := "~ app     " + Mask(old[0].Md5) + " -> " + Mask(current[0].Md5) + "\n" +
+		"+ batch   " + Mask(current[1].Md5) + "\n" +
+		"- legacy  " + Mask(old[1].Md5) + "\n"
+	if got != want {
+		t.Errorf("WriteDiff() = %q, want %q", got, want)
+	}
+
+	for _, user := range append(old, current...) {
+		if strings.Contains(got, user.Md5) {
+			t.Errorf("WriteDiff() discloses verifier of %s", user.UserName)
+		}
+	}
+}
+
+func TestMask(t *testing.T) {
+	tests := []struct {
+		verifier string
+		wantKind string
+	}{
+		{verifier: "md5d41d8cd98f00b204e9800998ecf8427e", wantKind: "md5 #"},
+		{verifier: "SCRAM-SHA-256$4096:salt$stored:server", wantKind: "SCRAM-SHA-256 #"},
+		{verifier: "secret", wantKind: "plain #"},
+		{verifier: "", wantKind: "empty #"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.wantKind, func(t *testing.T) {
+			got := Mask(tt.verifier)
+			if !strings.HasPrefix(got, tt.wantKind) || len(got) != len(tt.wantKind)+6 {
+				t.Errorf("Mask() = %q, want %q prefix and 6 digest digits", got, tt.wantKind)
+			}
+
+			if again := Mask(tt.verifier); again != got {
+				t.Errorf("Mask() = %q then %q for the same verifier", got, again)
+			}
+
+			// A plain hash of a guessed verifier must not match
+			sum := sha256.Sum256([]byte(tt.verifier))
+			if got == fmt.Sprintf("%s%x", tt.wantKind, sum[:3]) {
+				t.Errorf("Mask() = %q, an unkeyed digest", got)
+			}
+		})
+	}
+}
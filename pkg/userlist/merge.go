@@ -0,0 +1,97 @@
+package userlist
+
+import (
+	"fmt"
+	"sort"
+)
+
+type Precedence string
+
+const (
+	// Extra users replace database roles with the same name
+	PrecedenceExtra Precedence = "extra"
+	// Database roles replace extra users with the same name
+	PrecedenceDatabase Precedence = "database"
+)
+
+// MergeWriter inserts extra users into a sorted stream of users.
+type MergeWriter struct {
+	out        UsersWriter
+	extras     []*User
+	precedence Precedence
+	onShadow   func(userName string)
+}
+
+// NewMergeWriter writes users and extras to out in user name order. When a
+// user is in both, onShadow is called and precedence picks the one kept.
+func NewMergeWriter(out UsersWriter, extras []*User, precedence Precedence, onShadow func(userName string)) (UsersWriter, error) {
+	switch precedence {
+	case "":
+		precedence = PrecedenceExtra
+	case PrecedenceExtra, PrecedenceDatabase:
+	default:
+		return nil, fmt.Errorf("unknown extra users precedence %q, use %s or %s", precedence, PrecedenceExtra, PrecedenceDatabase)
+	}
+
+	// Last duplicate wins like in an auth file
+	unique := byName(extras)
+	sorted := make([]*User, 0, len(unique))
+	for name, md5 := range unique {
+		sorted = append(sorted, &User{UserName: name, Md5: md5})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].UserName < sorted[j].UserName
+	})
+
+	if onShadow == nil {
+		onShadow = func(string) {}
+	}
+
+	return &MergeWriter{
+		out:        out,
+		extras:     sorted,
+		precedence: precedence,
+		onShadow:   onShadow,
+	}, nil
+}
+
+func (m *MergeWriter) WriteHeader(source string) error {
+	return m.out.WriteHeader(source)
+}
+
+func (m *MergeWriter) Write(userName, md5 string) error {
+	// Extras sorted before userName go first
+	for len(m.extras) > 0 && m.extras[0].UserName < userName {
+		if err := m.writeExtra(); err != nil {
+			return err
+		}
+	}
+
+	if len(m.extras) > 0 && m.extras[0].UserName == userName {
+		m.onShadow(userName)
+		if m.precedence == PrecedenceExtra {
+			return m.writeExtra()
+		}
+		m.extras = m.extras[1:]
+	}
+
+	return m.out.Write(userName, md5)
+}
+
+// Close writes the remaining extras and closes out.
+func (m *MergeWriter) Close() error {
+	for len(m.extras) > 0 {
+		if err := m.writeExtra(); err != nil {
+			m.out.Close()
+			return err
+		}
+	}
+
+	return m.out.Close()
+}
+
+func (m *MergeWriter) writeExtra() error {
+	extra := m.extras[0]
+	m.extras = m.extras[1:]
+	return m.out.Write(extra.UserName, extra.Md5)
+}
@@ -0,0 +1,78 @@
+package userlist
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestMergeWriter(t *testing.T) {
+	database := [][2]string{{"app", "md5app"}, {"pgbouncer", "md5database"}, {"report", "md5report"}}
+	extras := []*User{
+		{UserName: "stats", Md5: "md5stats"},
+		{UserName: "pgbouncer", Md5: "md5extra"},
+		{UserName: "admin", Md5: "md5admin"},
+	}
+
+	tests := []struct {
+		name         string
+		precedence   Precedence
+		want         string
+		wantShadowed []string
+		wantErr      bool
+	}{
+		{
+			name:         "Extra precedence",
+			precedence:   PrecedenceExtra,
+			want:         "\"admin\" \"md5admin\"\n\"app\" \"md5app\"\n\"pgbouncer\" \"md5extra\"\n\"report\" \"md5report\"\n\"stats\" \"md5stats\"\n",
+			wantShadowed: []string{"pgbouncer"},
+		},
+		{
+			name:         "Default precedence",
+			want:         "\"admin\" \"md5admin\"\n\"app\" \"md5app\"\n\"pgbouncer\" \"md5extra\"\n\"report\" \"md5report\"\n\"stats\" \"md5stats\"\n",
+			wantShadowed: []string{"pgbouncer"},
+		},
+		{
+			name:         "Database precedence",
+			precedence:   PrecedenceDatabase,
+			want:         "\"admin\" \"md5admin\"\n\"app\" \"md5app\"\n\"pgbouncer\" \"md5database\"\n\"report\" \"md5report\"\n\"stats\" \"md5stats\"\n",
+			wantShadowed: []string{"pgbouncer"},
+		},
+		{
+			name:       "Unknown precedence",
+			precedence: "config",
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			shadowed := []string{}
+			w, err := NewMergeWriter(NewUsersWriter(buf), extras, tt.precedence, func(userName string) {
+				shadowed = append(shadowed, userName)
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewMergeWriter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			for _, user := range database {
+				if err := w.Write(user[0], user[1]); err != nil {
+					t.Fatalf("MergeWriter.Write() error = %v", err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("MergeWriter.Close() error = %v", err)
+			}
+
+			if got := buf.String(); got != tt.want {
+				t.Errorf("MergeWriter = %q, want %q", got, tt.want)
+			}
+			if !reflect.DeepEqual(shadowed, tt.wantShadowed) {
+				t.Errorf("MergeWriter shadowed = %v, want %v", shadowed, tt.wantShadowed)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package userlist
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	commentPrefix = ';'
+	quote         = '"'
+)
+
+var (
+	ErrInvalidField = errors.New("field can't hold a line break or a NUL byte")
+)
+
+// Parse reads a PGBouncer auth file: one `"user" "password"` entry per line,
+// double quotes inside a field are doubled, anything after the second field
+// is ignored, blank lines and lines starting with ';' are skipped.
+func Parse(r io.Reader) ([]*User, error) {
+	users := []*User{}
+
+	reader := bufio.NewReader(r)
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		user, parseErr := parseLine(line)
+		if parseErr != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, parseErr)
+		}
+
+		if user != nil {
+			users = append(users, user)
+		}
+
+		if err == io.EOF {
+			return users, nil
+		}
+	}
+}
+
+// parseLine returns a nil user for blank and comment lines.
+func parseLine(line string) (*User, error) {
+	line = strings.TrimRight(line, "\r\n")
+	rest := strings.TrimLeft(line, " \t")
+	if rest == "" || rest[0] == commentPrefix {
+		return nil, nil
+	}
+
+	userName, rest, err := unquote(rest)
+	if err != nil {
+		return nil, fmt.Errorf("user name: %w", err)
+	}
+
+	password, _, err := unquote(strings.TrimLeft(rest, " \t"))
+	if err != nil {
+		return nil, fmt.Errorf("password of %q: %w", userName, err)
+	}
+
+	return &User{
+		UserName: userName,
+		Md5:      password,
+	}, nil
+}
+
+// unquote reads a double quoted field at the start of s and returns its
+// value and what follows the closing quote.
+func unquote(s string) (string, string, error) {
+	if s == "" || s[0] != quote {
+		return "", "", errors.New("expected a double quoted field")
+	}
+
+	value := new(strings.Builder)
+	for i := 1; i < len(s); i++ {
+		if s[i] != quote {
+			value.WriteByte(s[i])
+			continue
+		}
+
+		// A doubled quote is a literal quote
+		if i+1 < len(s) && s[i+1] == quote {
+			value.WriteByte(quote)
+			i++
+			continue
+		}
+
+		return value.String(), s[i+1:], nil
+	}
+
+	return "", "", errors.New("missing closing double quote")
+}
+
+// quoteField escapes a field the way Parse reads it back.
+func quoteField(s string) (string, error) {
+	if strings.ContainsAny(s, "\r\n\x00") {
+		return "", fmt.Errorf("%w: %q", ErrInvalidField, s)
+	}
+
+	return string(quote) + strings.ReplaceAll(s, string(quote), string(quote)+string(quote)) + string(quote), nil
+}
@@ -0,0 +1,284 @@
+package userlist
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    []*User
+		wantErr bool
+	}{
+		{
+			name: "Entries",
+			in:   "\"app\" \"md5app\"\n\"postgres\" \"md5postgres\"\n",
+			want: []*User{
+				{UserName: "app", Md5: "md5app"},
+				{UserName: "postgres", Md5: "md5postgres"},
+			},
+		},
+		{
+			name: "Comments, blank lines and trailing fields",
+			in:   "; generated by pgbouncer-updater\n\n  ; indented comment\r\n\t\"app\"  \"md5app\" \"ignored\" trailing\r\n\"last\" \"no newline\"",
+			want: []*User{
+				{UserName: "app", Md5: "md5app"},
+				{UserName: "last", Md5: "no newline"},
+			},
+		},
+		{
+			name: "Doubled quotes",
+			in:   "\"say \"\"hi\"\"\" \"pass\"\"word\"\n",
+			want: []*User{
+				{UserName: "say \"hi\"", Md5: "pass\"word"},
+			},
+		},
+		{
+			name: "Empty fields",
+			in:   "\"\" \"\"\n",
+			want: []*User{
+				{UserName: "", Md5: ""},
+			},
+		},
+		{
+			name: "Empty file",
+			in:   "",
+			want: []*User{},
+		},
+		{
+			name:    "Unquoted user",
+			in:      "app \"md5app\"\n",
+			wantErr: true,
+		},
+		{
+			name:    "Missing password",
+			in:      "\"app\"\n",
+			wantErr: true,
+		},
+		{
+			name:    "Unterminated quote",
+			in:      "\"app\" \"md5app\n",
+			wantErr: true,
+		},
+		{
+			name:    "Hash comment",
+			in:      "# not a comment for PGBouncer\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(strings.NewReader(tt.in))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse_RoundTrip(t *testing.T) {
+	users := []*User{
+		{UserName: "\"quoted\"", Md5: "md5d41d8cd98f00b204e9800998ecf8427e"},
+		{UserName: "app", Md5: "SCRAM-SHA-256$4096:salt$stored:server"},
+		{UserName: "semi;colon", Md5: "; not a comment"},
+		{UserName: "space name", Md5: "tab\tpassword"},
+	}
+
+	buf := new(bytes.Buffer)
+	if err := NewUserList(buf).WriteMany(users); err != nil {
+		t.Fatalf("User.WriteMany() error = %v", err)
+	}
+
+	got, err := Parse(buf)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	for _, user := range got {
+		user.file = nil
+	}
+	for _, user := range users {
+		user.file = nil
+	}
+	if !reflect.DeepEqual(got, users) {
+		t.Errorf("Parse(WriteMany()) = %v, want %v", got, users)
+	}
+}
+
+func TestUser_WriteInvalidField(t *testing.T) {
+	for _, name := range []string{"line\nbreak", "carriage\rreturn", "nul\x00byte"} {
+		err := NewUserList(new(bytes.Buffer)).WriteMany(map[string]string{name: "md5"})
+		if err == nil {
+			t.Errorf("User.WriteMany(%q) error = nil", name)
+		}
+	}
+}
+
+// TestWriter_RoundTrip checks every user the writer accepts is read back
+// unchanged.
+func TestWriter_RoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		userName string
+		md5      string
+	}{
+		{name: "Plain", userName: "postgres", md5: "md5d41d8cd98f00b204e9800998ecf8427e"},
+		{name: "Quotes", userName: "say \"hi\"", md5: "\"\""},
+		{name: "Comment like", userName: " ;comment", md5: "SCRAM-SHA-256$4096:salt$stored:server"},
+		{name: "Empty", userName: "", md5: ""},
+		{name: "Spaces and tabs", userName: "a b\tc", md5: " md5 "},
+		{name: "Unicode", userName: "rôle", md5: "md5é"},
+	}
+	for _, seed := range readCorpus(t, "FuzzRoundTrip") {
+		if len(seed.args) != 2 {
+			t.Fatalf("corpus %s has %d arguments, want 2", seed.name, len(seed.args))
+		}
+		tests = append(tests, struct {
+			name     string
+			userName string
+			md5      string
+		}{name: "Corpus " + seed.name, userName: seed.args[0], md5: seed.args[1]})
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			w := NewUsersWriter(buf)
+			if err := w.Write(tt.userName, tt.md5); err != nil {
+				if strings.ContainsAny(tt.userName+tt.md5, "\r\n\x00") {
+					return
+				}
+				t.Fatalf("Writer.Write(%q, %q) error = %v", tt.userName, tt.md5, err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := Parse(buf)
+			if err != nil {
+				t.Fatalf("Parse(%q) error = %v", buf.String(), err)
+			}
+			if len(got) != 1 || got[0].UserName != tt.userName || got[0].Md5 != tt.md5 {
+				t.Errorf("Parse(%q) = %v, want %q %q", buf.String(), got, tt.userName, tt.md5)
+			}
+		})
+	}
+}
+
+// TestParse_Rewrite checks any list Parse accepts is written back to a
+// list Parse reads the same.
+func TestParse_Rewrite(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+	}{
+		{name: "Entry", in: "\"app\" \"md5app\"\n"},
+		{name: "Comments and escapes", in: "; comment\n\n\"a\"\"b\" \"c\" trailing\r\n"},
+		{name: "No final line feed", in: "\"app\" \"md5app\""},
+		{name: "Several entries", in: "\"a\" \"1\"\n\"b\"\t\"2\"\n\"\"\"\" \"\"\n"},
+	}
+	for _, seed := range readCorpus(t, "FuzzParse") {
+		if len(seed.args) != 1 {
+			t.Fatalf("corpus %s has %d arguments, want 1", seed.name, len(seed.args))
+		}
+		tests = append(tests, struct {
+			name string
+			in   string
+		}{name: "Corpus " + seed.name, in: seed.args[0]})
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users, err := Parse(strings.NewReader(tt.in))
+			if err != nil {
+				// Only lists Parse accepts are rewritten
+				return
+			}
+
+			buf := new(bytes.Buffer)
+			for _, user := range users {
+				user.file = buf
+				if err := user.write(); err != nil {
+					if strings.ContainsAny(user.UserName+user.Md5, "\r\n\x00") {
+						return
+					}
+					t.Fatalf("User.write() error = %v", err)
+				}
+			}
+
+			got, err := Parse(buf)
+			if err != nil {
+				t.Fatalf("Parse(%q) error = %v", buf.String(), err)
+			}
+			if len(got) != len(users) {
+				t.Fatalf("Parse(%q) = %d users, want %d", buf.String(), len(got), len(users))
+			}
+			for i := range got {
+				if got[i].UserName != users[i].UserName || got[i].Md5 != users[i].Md5 {
+					t.Errorf("Parse(%q)[%d] = %v, want %v", buf.String(), i, got[i], users[i])
+				}
+			}
+		})
+	}
+}
+
+// corpusSeed is a seed file of a fuzz corpus and its string arguments.
+type corpusSeed struct {
+	name string
+	args []string
+}
+
+// readCorpus returns the fuzz seed corpus of target, in the go test fuzz v1
+// format so it serves again once the module is on a Go version with native
+// fuzzing.
+func readCorpus(t *testing.T, target string) []corpusSeed {
+	t.Helper()
+
+	dir := filepath.Join("testdata", "fuzz", target)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	corpus := []corpusSeed{}
+	for _, entry := range entries {
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		lines := strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+		if len(lines) == 0 || lines[0] != "go test fuzz v1" {
+			t.Fatalf("corpus %s: not a go test fuzz v1 file", entry.Name())
+		}
+
+		args := []string{}
+		for _, line := range lines[1:] {
+			if !strings.HasPrefix(line, "string(") || !strings.HasSuffix(line, ")") {
+				t.Fatalf("corpus %s: unsupported argument %s", entry.Name(), line)
+			}
+
+			arg, err := strconv.Unquote(line[len("string(") : len(line)-1])
+			if err != nil {
+				t.Fatalf("corpus %s: %v", entry.Name(), err)
+			}
+			args = append(args, arg)
+		}
+		corpus = append(corpus, corpusSeed{entry.Name(), args})
+	}
+
+	if len(corpus) == 0 {
+		t.Fatalf("no seed corpus in %s", dir)
+	}
+
+	return corpus
+}
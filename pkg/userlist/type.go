@@ -1,9 +1,13 @@
 package userlist
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"reflect"
+	"sort"
+	"strings"
 )
 
 type User struct {
@@ -13,17 +17,39 @@ type User struct {
 }
 
 const (
-	formatedList = "\"%s\" \"%s\"\n"
+	formatedList = "%s %s\n"
+	// PGBouncer skips auth file lines starting with a semicolon
+	HeaderPrefix = "; generated by pgbouncer-updater"
+	headerFormat = HeaderPrefix + " from %s\n"
 )
 
+var (
+	ErrUnsorted = errors.New("users are not sorted by user name, add ORDER BY rolname to the query")
+	ErrHeader   = errors.New("header must be written before users")
+)
+
+type Writer struct {
+	buf     *bufio.Writer
+	closer  io.Closer
+	last    string
+	started bool
+}
+
+// WriteMany writes users sorted by user name, one line per user.
 func (u *User) WriteMany(users interface{}) error {
 	switch list := users.(type) {
 	case map[string]string:
-		for k, v := range list {
+		names := make([]string, 0, len(list))
+		for name := range list {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
 			user := &User{
 				file:     u.file,
-				UserName: k,
-				Md5:      v,
+				UserName: name,
+				Md5:      list[name],
 			}
 
 			if err := user.write(); err != nil {
@@ -32,7 +58,13 @@ func (u *User) WriteMany(users interface{}) error {
 		}
 
 	case []*User:
-		for _, user := range list {
+		sorted := make([]*User, len(list))
+		copy(sorted, list)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return sorted[i].UserName < sorted[j].UserName
+		})
+
+		for _, user := range sorted {
 			user.file = u.file
 			if err := user.write(); err != nil {
 				return err
@@ -45,9 +77,81 @@ func (u *User) WriteMany(users interface{}) error {
 }
 
 func (u *User) write() error {
-	_, err := fmt.Fprintf(u.file, formatedList, u.UserName, u.Md5)
+	userName, err := quoteField(u.UserName)
+	if err != nil {
+		return err
+	}
+
+	md5, err := quoteField(u.Md5)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(u.file, formatedList, userName, md5)
 	if err != nil {
 		return err
 	}
 	return nil
 }
+
+func (w *Writer) WriteHeader(source string) error {
+	if w.started {
+		return ErrHeader
+	}
+
+	_, err := fmt.Fprintf(w.buf, headerFormat, source)
+	return err
+}
+
+func (w *Writer) Write(userName, md5 string) error {
+	if w.started && userName <= w.last {
+		return fmt.Errorf("%w: %q after %q", ErrUnsorted, userName, w.last)
+	}
+	w.last, w.started = userName, true
+
+	user := &User{
+		file:     w.buf,
+		UserName: userName,
+		Md5:      md5,
+	}
+
+	return user.write()
+}
+
+func (w *Writer) Close() error {
+	err := w.buf.Flush()
+	if w.closer != nil {
+		if closeErr := w.closer.Close(); err == nil {
+			err = closeErr
+		}
+		w.closer = nil
+	}
+
+	return err
+}
+
+// headerFilter drops generated header lines so two lists only differing by
+// their header compare equal.
+type headerFilter struct {
+	src  *bufio.Reader
+	line []byte
+	err  error
+}
+
+func (f *headerFilter) Read(p []byte) (int, error) {
+	for len(f.line) == 0 {
+		if f.err != nil {
+			return 0, f.err
+		}
+
+		var line string
+		line, f.err = f.src.ReadString('\n')
+		if !strings.HasPrefix(line, HeaderPrefix) {
+			f.line = []byte(line)
+		}
+	}
+
+	n := copy(p, f.line)
+	f.line = f.line[n:]
+	return n, nil
+}
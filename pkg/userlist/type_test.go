@@ -2,6 +2,9 @@ package userlist
 
 import (
 	"bytes"
+	"fmt"
+	"io"
+	"strings"
 	"testing"
 )
 
@@ -63,3 +66,189 @@ func TestUser_WriteMany(t *testing.T) {
 
 	}
 }
+
+func TestWriter_Write(t *testing.T) {
+	tests := []struct {
+		name    string
+		users   [][2]string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "Sorted",
+			users: [][2]string{{"app", "md5app"}, {"postgres", "md5postgres"}},
+			want:  "\"app\" \"md5app\"\n\"postgres\" \"md5postgres\"\n",
+		},
+		{
+			name:    "Unsorted",
+			users:   [][2]string{{"postgres", "md5postgres"}, {"app", "md5app"}},
+			wantErr: true,
+		},
+		{
+			name:    "Duplicated",
+			users:   [][2]string{{"app", "md5app"}, {"app", "md5app"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			w := NewUsersWriter(buf)
+
+			var err error
+			for _, user := range tt.users {
+				if err = w.Write(user[0], user[1]); err != nil {
+					break
+				}
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Writer.Write() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if err := w.Close(); err != nil {
+				t.Errorf("Writer.Close() error = %v", err)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("Writer.Write() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+const benchmarkUsers = 50000
+
+func benchmarkUserName(i int) string {
+	return fmt.Sprintf("tenant_%06d", i)
+}
+
+func BenchmarkUser_WriteMany(b *testing.B) {
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		users := make(map[string]string, benchmarkUsers)
+		for i := 0; i < benchmarkUsers; i++ {
+			users[benchmarkUserName(i)] = "md5d41d8cd98f00b204e9800998ecf8427e"
+		}
+
+		list := NewUserList(io.Discard)
+		if err := list.WriteMany(users); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWriter_Write(b *testing.B) {
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		w := NewUsersWriter(io.Discard)
+		for i := 0; i < benchmarkUsers; i++ {
+			if err := w.Write(benchmarkUserName(i), "md5d41d8cd98f00b204e9800998ecf8427e"); err != nil {
+				b.Fatal(err)
+			}
+		}
+
+		if err := w.Close(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestUser_WriteManySorted(t *testing.T) {
+	want := "\"app\" \"md5app\"\n\"batch\" \"md5batch\"\n\"postgres\" \"md5postgres\"\n"
+
+	tests := []struct {
+		name  string
+		users interface{}
+	}{
+		{
+			name: "Map",
+			users: map[string]string{
+				"postgres": "md5postgres",
+				"batch":    "md5batch",
+				"app":      "md5app",
+			},
+		},
+		{
+			name: "Slice",
+			users: []*User{
+				{UserName: "postgres", Md5: "md5postgres"},
+				{UserName: "app", Md5: "md5app"},
+				{UserName: "batch", Md5: "md5batch"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Map iteration order changes between runs, write several times
+			for i := 0; i < 10; i++ {
+				buf := new(bytes.Buffer)
+				if err := NewUserList(buf).WriteMany(tt.users); err != nil {
+					t.Fatalf("User.WriteMany() error = %v", err)
+				}
+				if got := buf.String(); got != want {
+					t.Fatalf("User.WriteMany() = %q, want %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestWriter_WriteHeader(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewUsersWriter(buf)
+
+	if err := w.WriteHeader("postgres@db:5432/postgres"); err != nil {
+		t.Fatalf("Writer.WriteHeader() error = %v", err)
+	}
+	if err := w.Write("app", "md5app"); err != nil {
+		t.Fatalf("Writer.Write() error = %v", err)
+	}
+	if err := w.WriteHeader("again"); err == nil {
+		t.Errorf("Writer.WriteHeader() after users error = nil")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Writer.Close() error = %v", err)
+	}
+
+	want := "; generated by pgbouncer-updater from postgres@db:5432/postgres\n\"app\" \"md5app\"\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Writer.WriteHeader() = %q, want %q", got, want)
+	}
+}
+
+func TestWithoutHeader(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "Header",
+			in:   "; generated by pgbouncer-updater from postgres@db:5432/postgres\n\"app\" \"md5app\"\n",
+			want: "\"app\" \"md5app\"\n",
+		},
+		{
+			name: "Other comments kept",
+			in:   "; local user\n\"app\" \"md5app\"",
+			want: "; local user\n\"app\" \"md5app\"",
+		},
+		{
+			name: "Empty",
+			in:   "",
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := io.ReadAll(WithoutHeader(strings.NewReader(tt.in)))
+			if err != nil {
+				t.Fatalf("WithoutHeader() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("WithoutHeader() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
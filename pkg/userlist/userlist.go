@@ -1,6 +1,7 @@
 package userlist
 
 import (
+	"bufio"
 	"io"
 	"os"
 )
@@ -9,6 +10,16 @@ type UsersList interface {
 	WriteMany(users interface{}) error
 }
 
+// UsersWriter writes users one by one in ascending user name order, only
+// the current line is held in memory.
+type UsersWriter interface {
+	// WriteHeader records the list source in a comment, before any user
+	WriteHeader(source string) error
+	Write(userName, md5 string) error
+	// Flush buffered lines and close the file when the writer owns it
+	Close() error
+}
+
 func NewUserList(file io.Writer) UsersList {
 	return &User{
 		file: file,
@@ -23,3 +34,39 @@ func NewUserListToFile(filePath string) (UsersList, error) {
 
 	return NewUserList(f), nil
 }
+
+func NewUsersWriter(file io.Writer) UsersWriter {
+	return &Writer{
+		buf: bufio.NewWriter(file),
+	}
+}
+
+func NewUsersWriterToFile(filePath string) (UsersWriter, error) {
+	f, err := os.Create(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Writer{
+		buf:    bufio.NewWriter(f),
+		closer: f,
+	}, nil
+}
+
+// WithoutHeader returns a reader skipping the generated header line.
+func WithoutHeader(r io.Reader) io.Reader {
+	return &headerFilter{
+		src: bufio.NewReader(r),
+	}
+}
+
+// ParseFile parses the auth file at filePath.
+func ParseFile(filePath string) ([]*User, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Parse(f)
+}